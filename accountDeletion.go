@@ -0,0 +1,140 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+const defaultAccountDeletionGrace = 30 * 24 * time.Hour
+
+func accountDeletionGraceFromEnv() time.Duration {
+	value := os.Getenv("ACCOUNT_DELETION_GRACE")
+	if value == "" {
+		return defaultAccountDeletionGrace
+	}
+
+	grace, err := time.ParseDuration(value)
+	if err != nil || grace < 0 {
+		fmt.Printf("Invalid ACCOUNT_DELETION_GRACE %q, using %v\n", value, defaultAccountDeletionGrace)
+		return defaultAccountDeletionGrace
+	}
+
+	return grace
+}
+
+func (cfg *apiConfig) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	err = db.SoftDeleteUser(userID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error writing database")
+		return
+	}
+
+	cfg.revokeTokensOfUser(db, userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runAccountPurger hard-deletes accounts whose grace period is over. It runs
+// once at start so purges cut short by a restart are finished right away.
+func (cfg *apiConfig) runAccountPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cfg.purgeDueAccounts()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) purgeDueAccounts() {
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+
+	users, err := db.UsersToPurge(time.Now().Add(-cfg.accountDeletionGrace))
+	if err != nil {
+		fmt.Printf("Error loading accounts to purge: %v\n", err)
+		return
+	}
+
+	for _, user := range users {
+		err := cfg.purgeAccount(db, user)
+		if err != nil {
+			fmt.Printf("Error purging account %d: %v\n", user.Id, err)
+			continue
+		}
+
+		fmt.Printf("Account purged: %d\n", user.Id)
+	}
+}
+
+// purgeAccount runs the remaining purge stages of a user. Each stage is safe
+// to repeat and records the next one before returning.
+func (cfg *apiConfig) purgeAccount(db *database.DB, user models.User) error {
+	stage := user.PurgeStage
+	if stage == "" {
+		stage = models.PurgeStageMedia
+		err := db.SetPurgeStage(user.Id, stage)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		var err error
+
+		switch stage {
+		case models.PurgeStageMedia:
+			err = cfg.media.Delete(user.AvatarFile)
+			if err == nil {
+				err = cfg.deleteExportFiles(db, user.Id)
+			}
+			if err == nil {
+				err = db.SetPurgeStage(user.Id, models.PurgeStageChirps)
+			}
+			stage = models.PurgeStageChirps
+		case models.PurgeStageChirps:
+			err = db.PurgeChirps(user.Id)
+			stage = models.PurgeStageRelations
+		case models.PurgeStageRelations:
+			err = db.PurgeRelations(user.Id)
+			stage = models.PurgeStageAccount
+		case models.PurgeStageAccount:
+			return db.DeleteUserRecord(user.Id)
+		default:
+			return fmt.Errorf("unknown purge stage %q", stage)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	apiKeyPrefix      = "chirpy_"
+	maxAPIKeysPerUser = 25
+	maxAPIKeyName     = 50
+)
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type apiKeysResponse struct {
+	Keys []models.APIKeyResponse `json:"keys"`
+}
+
+// createAPIKey returns the secret once; afterwards only its prefix is shown.
+func (cfg *apiConfig) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	var request createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxAPIKeyName {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("name must be 1 to %d characters", maxAPIKeyName))
+		return
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	scope, err := grantDelegatedScopes(user, request.Scope)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	secret, err := newSecretToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating API key")
+		return
+	}
+	secret = apiKeyPrefix + secret
+
+	var expiresAt *time.Time
+	if request.ExpiresAt != nil {
+		utc := request.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	key, err := db.CreateAPIKey(models.APIKey{
+		UserId:     userID,
+		Name:       name,
+		Prefix:     secret[:len(apiKeyPrefix)+8],
+		SecretHash: hashToken(secret),
+		Scope:      scope,
+		ExpiresAt:  expiresAt,
+	}, maxAPIKeysPerUser)
+	if errors.Is(err, database.ErrConflict) {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("You can have at most %d API keys", maxAPIKeysPerUser))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating API key")
+		return
+	}
+
+	response := key.Response()
+	response.Key = secret
+
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	keys, err := db.GetAPIKeysOfUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error loading API keys")
+		return
+	}
+
+	response := apiKeysResponse{Keys: make([]models.APIKeyResponse, 0, len(keys))}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, key.Response())
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	keyID, err := strconv.Atoi(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid keyID")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	err = db.RevokeAPIKey(userID, keyID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking API key")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseAPIKey turns an "ApiKey" Authorization header into the same claims an
+// access token would carry. The scopes are checked against what the user may
+// do now, so a demoted moderator's key loses admin. Revoking every token of
+// the user, e.g. on a password change, revokes the keys created before too.
+func (cfg *apiConfig) parseAPIKey(secret string) (*accessClaims, error) {
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	key, err := db.UseAPIKey(hashToken(secret))
+	if errors.Is(err, database.ErrExpired) {
+		return nil, errors.New("API key has expired")
+	}
+	if err != nil {
+		return nil, errors.New("invalid API key")
+	}
+
+	user, err := db.GetUser(key.UserId)
+	if err != nil || user.Suspended || user.Deleted() {
+		return nil, errors.New("invalid API key")
+	}
+
+	if user.TokensRevokedBefore != nil && key.CreatedAt.Truncate(jwt.TimePrecision).Before(*user.TokensRevokedBefore) {
+		return nil, errors.New("API key has been revoked")
+	}
+
+	scope, err := grantScopes(user, key.Scope)
+	if err != nil {
+		return nil, errors.New("API key has no usable scope")
+	}
+
+	claims := &accessClaims{Scope: scope, Role: user.Role}
+	claims.Subject = strconv.Itoa(user.Id)
+	claims.Issuer = "chirpy"
+
+	return claims, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"testing"
+	"time"
+)
+
+func createTestAPIKey(t *testing.T, db *database.DB, userID int) string {
+	t.Helper()
+
+	secret, err := newSecretToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret = apiKeyPrefix + secret
+
+	_, err = db.CreateAPIKey(models.APIKey{
+		UserId:     userID,
+		Name:       "bot",
+		Prefix:     secret[:len(apiKeyPrefix)+8],
+		SecretHash: hashToken(secret),
+		Scope:      scopeChirpsWrite,
+	}, maxAPIKeysPerUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return secret
+}
+
+func TestAPIKeysRevokedWithTokens(t *testing.T) {
+	cfg := newTestConfig(t, "http://chirpy.test")
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := createTestUser(t, "walt@example.com")
+	oldKey := createTestAPIKey(t, db, user.Id)
+	if _, err := cfg.parseAPIKey(oldKey); err != nil {
+		t.Fatalf("using a new key: %v", err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	cfg.revokeTokensOfUser(db, user.Id)
+	time.Sleep(2 * time.Millisecond)
+
+	if _, err := cfg.parseAPIKey(oldKey); err == nil {
+		t.Fatal("a key created before revoking every token still works")
+	}
+
+	newKey := createTestAPIKey(t, db, user.Id)
+	if _, err := cfg.parseAPIKey(newKey); err != nil {
+		t.Fatalf("using a key created after revoking every token: %v", err)
+	}
+}
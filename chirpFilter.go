@@ -0,0 +1,99 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type chirpFilter struct {
+	viewer           *models.User
+	excludeSensitive bool
+	deletedAuthors   map[int]bool
+	blockedAuthors   map[int]bool
+	mutedAuthors     map[int]bool
+}
+
+func (cfg *apiConfig) newChirpFilter(r *http.Request, db *database.DB) chirpFilter {
+	filter := chirpFilter{
+		excludeSensitive: r.URL.Query().Get("sensitive") == "false",
+	}
+
+	deletedAuthors, err := db.DeletedUserIDs()
+	if err != nil {
+		fmt.Printf("Error loading deleted users: %v\n", err)
+	}
+	filter.deletedAuthors = deletedAuthors
+
+	// Readers in cookie mode send no Authorization header.
+	if _, err := r.Cookie(accessCookie); r.Header.Get("Authorization") == "" && err != nil {
+		return filter
+	}
+
+	claims, err := cfg.authenticate(r)
+	if err != nil {
+		return filter
+	}
+
+	viewerID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return filter
+	}
+
+	viewer, err := db.GetUser(viewerID)
+	if err != nil {
+		return filter
+	}
+	filter.viewer = viewer
+
+	filter.blockedAuthors, filter.mutedAuthors, err = db.HiddenAuthors(viewerID)
+	if err != nil {
+		fmt.Printf("Error loading blocks and mutes: %v\n", err)
+	}
+
+	return filter
+}
+
+// visible decides whether the viewer may open a single chirp.
+func (f chirpFilter) visible(chirp *models.Chirp) bool {
+	if chirp.Expired(time.Now()) || chirp.Hidden || chirp.Held {
+		return false
+	}
+
+	return !f.deletedAuthors[chirp.AuthorId] && !f.blockedAuthors[chirp.AuthorId]
+}
+
+// listed decides whether a chirp shows up in lists of chirps. On top of
+// visible it drops muted authors and sensitive chirps the viewer opted out of.
+func (f chirpFilter) listed(chirp *models.Chirp) bool {
+	if !f.visible(chirp) || f.mutedAuthors[chirp.AuthorId] {
+		return false
+	}
+
+	if chirp.Sensitive {
+		if f.excludeSensitive {
+			return false
+		}
+
+		if f.viewer != nil && f.viewer.Preferences.HidesSensitive() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f chirpFilter) apply(chirps []models.Storable) []models.Storable {
+	listedChirps := []models.Storable{}
+
+	for _, chirp := range chirps {
+		if f.listed(chirp.(*models.Chirp)) {
+			listedChirps = append(listedChirps, chirp)
+		}
+	}
+
+	return listedChirps
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func createTestChirp(t *testing.T, db *database.DB, authorID int, body string) {
+	t.Helper()
+
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chirp, err := db.CreateChirp(`{"body": "`+body+`"}`, authorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.WriteDB(loadDB, chirp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChirpFilterInCookieMode(t *testing.T) {
+	cfg := newTestConfig(t, "http://chirpy.test")
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viewer := createTestUser(t, "walt@example.com")
+	blocked := createTestUser(t, "tuco@example.com")
+	muted := createTestUser(t, "saul@example.com")
+	other := createTestUser(t, "jesse@example.com")
+
+	createTestChirp(t, db, blocked.Id, "from a blocked author")
+	createTestChirp(t, db, muted.Id, "from a muted author")
+	createTestChirp(t, db, other.Id, "from anyone else")
+
+	if err := db.Block(viewer.Id, blocked.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Mute(viewer.Id, muted.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	login := httptest.NewRecorder()
+	cfg.login(login, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(
+		`{"email": "walt@example.com", "password": "`+testPassword+`", "cookie": true}`,
+	)))
+	if login.Code != http.StatusOK {
+		t.Fatalf("login: status %d", login.Code)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+	for _, cookie := range login.Result().Cookies() {
+		request.AddCookie(cookie)
+	}
+	if _, err := request.Cookie(accessCookie); err != nil {
+		t.Fatal("login set no access cookie")
+	}
+
+	chirps, err := db.GetItems("chirp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter := cfg.newChirpFilter(request, db)
+	if filter.viewer == nil || filter.viewer.Id != viewer.Id {
+		t.Fatalf("got viewer %v, want user %d", filter.viewer, viewer.Id)
+	}
+
+	listed := filter.apply(chirps)
+	if len(listed) != 1 || listed[0].(*models.Chirp).AuthorId != other.Id {
+		t.Fatalf("got %d chirps listed, want only the one by user %d", len(listed), other.Id)
+	}
+}
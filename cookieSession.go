@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cookie mode keeps the tokens of browsers out of reach of scripts. The CSRF
+// token is the only cookie scripts can read; unsafe requests repeat it in the
+// X-CSRF-Token header, which another site can't do.
+const (
+	accessCookie  = "chirpy_access"
+	refreshCookie = "chirpy_refresh"
+	csrfCookie    = "chirpy_csrf"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+var errCSRF = errors.New("missing or invalid CSRF token")
+
+func setSessionCookies(w http.ResponseWriter, accessToken string, accessExpiresAt time.Time, refreshToken string, csrfToken string) {
+	refreshMaxAge := int(refreshTokenTTL.Seconds())
+
+	http.SetCookie(w, sessionCookie(accessCookie, accessToken, "/", int(time.Until(accessExpiresAt).Seconds()), true))
+	// The refresh token is only needed by /api/refresh and /api/revoke.
+	http.SetCookie(w, sessionCookie(refreshCookie, refreshToken, "/api", refreshMaxAge, true))
+	http.SetCookie(w, sessionCookie(csrfCookie, csrfToken, "/", refreshMaxAge, false))
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, sessionCookie(accessCookie, "", "/", -1, true))
+	http.SetCookie(w, sessionCookie(refreshCookie, "", "/api", -1, true))
+	http.SetCookie(w, sessionCookie(csrfCookie, "", "/", -1, false))
+}
+
+func sessionCookie(name string, value string, path string, maxAge int, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: httpOnly,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// checkCSRF checks unsafe requests authenticated by the access cookie. The
+// header must match the CSRF token the access token was issued with, so a
+// cookie planted by another site doesn't help either.
+func checkCSRF(r *http.Request, claims *accessClaims) error {
+	if !unsafeMethod(r.Method) {
+		return nil
+	}
+
+	if !tokenMatchesHash(r.Header.Get(csrfHeader), claims.CSRFHash) {
+		return errCSRF
+	}
+
+	return nil
+}
+
+// refreshTokenFromRequest takes the refresh token from the Authorization
+// header or, in cookie mode, from its cookie. The access token may have
+// expired by now, so there the CSRF header is checked against the CSRF
+// cookie instead.
+func refreshTokenFromRequest(r *http.Request) (string, bool, error) {
+	if headerAuth := r.Header.Get("Authorization"); headerAuth != "" {
+		return strings.TrimPrefix(headerAuth, "Bearer "), false, nil
+	}
+
+	cookie, err := r.Cookie(refreshCookie)
+	if err != nil {
+		return "", false, nil
+	}
+
+	csrf, err := r.Cookie(csrfCookie)
+	if err != nil || csrf.Value == "" || subtle.ConstantTimeCompare([]byte(csrf.Value), []byte(r.Header.Get(csrfHeader))) != 1 {
+		return "", true, errCSRF
+	}
+
+	return cookie.Value, true, nil
+}
+
+func unsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+
+	return true
+}
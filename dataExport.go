@@ -0,0 +1,448 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	exportLinkTTL = 15 * time.Minute
+	// exportRetention is how long a built archive stays downloadable.
+	exportRetention = 7 * 24 * time.Hour
+)
+
+type exportedProfile struct {
+	Id          int                `json:"id"`
+	Email       string             `json:"email"`
+	Handle      string             `json:"handle"`
+	DisplayName string             `json:"display_name"`
+	Bio         string             `json:"bio"`
+	AvatarFile  string             `json:"avatar_file,omitempty"`
+	IsChirpyRed bool               `json:"is_chirpy_red"`
+	Preferences models.Preferences `json:"preferences"`
+	CreatedAt   time.Time          `json:"created_at"`
+}
+
+// exportedReport holds what the user wrote in a report. Who handled it and
+// how is the moderators' data.
+type exportedReport struct {
+	Id        int       `json:"id"`
+	ChirpId   int       `json:"chirp_id"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportedRelations struct {
+	Following []int `json:"following"`
+	Followers []int `json:"followers"`
+	Blocked   []int `json:"blocked"`
+	Muted     []int `json:"muted"`
+}
+
+func (cfg *apiConfig) startExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	export, err := db.CreateExport(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error writing database")
+		return
+	}
+
+	cfg.runExport(export.Id)
+
+	respondWithJSON(w, http.StatusAccepted, exportResponse(export, ""))
+}
+
+func (cfg *apiConfig) getExportStatus(w http.ResponseWriter, r *http.Request) {
+	db, export, ok := ownExport(w, r)
+	if !ok {
+		return
+	}
+
+	if export.Status != models.ExportStatusReady {
+		respondWithJSON(w, http.StatusOK, exportResponse(export, ""))
+		return
+	}
+
+	token, err := newSecretToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating download link")
+		return
+	}
+
+	export, err = db.UpdateExport(export.Id, func(export *models.DataExport) error {
+		expiresAt := time.Now().Add(exportLinkTTL).UTC()
+		export.DownloadTokenHash = hashToken(token)
+		export.DownloadExpiresAt = &expiresAt
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error writing database")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, exportResponse(export, token))
+}
+
+// downloadExport is reached through the link from the status endpoint, so it
+// authenticates with the short-lived token in the query instead of a JWT.
+func (cfg *apiConfig) downloadExport(w http.ResponseWriter, r *http.Request) {
+	exportID, err := strconv.Atoi(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "export not found")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	export, err := db.GetExport(exportID)
+	if err != nil || export.Status != models.ExportStatusReady {
+		respondWithError(w, http.StatusNotFound, "export not found")
+		return
+	}
+
+	expired := export.DownloadExpiresAt == nil || time.Now().After(*export.DownloadExpiresAt)
+	if expired || !tokenMatchesHash(r.URL.Query().Get("token"), export.DownloadTokenHash) {
+		respondWithError(w, http.StatusForbidden, "Download link is invalid or has expired")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%d.zip"`, export.Id))
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	http.ServeFile(w, r, filepath.Join(cfg.exportDir, export.FileName))
+}
+
+// runExport builds the archive in the background unless a build of the same
+// export is already running.
+func (cfg *apiConfig) runExport(exportID int) {
+	if _, running := cfg.runningExports.LoadOrStore(exportID, true); running {
+		return
+	}
+
+	go func() {
+		defer cfg.runningExports.Delete(exportID)
+
+		db, err := database.NewDB("database.json")
+		if err != nil {
+			fmt.Printf("Error opening database: %v\n", err)
+			return
+		}
+
+		fileName, buildErr := cfg.buildExport(db, exportID)
+
+		_, err = db.UpdateExport(exportID, func(export *models.DataExport) error {
+			completedAt := time.Now().UTC()
+			export.CompletedAt = &completedAt
+
+			if buildErr != nil {
+				export.Status = models.ExportStatusFailed
+				export.Error = "export could not be built"
+				return nil
+			}
+
+			expiresAt := completedAt.Add(exportRetention)
+			export.Status = models.ExportStatusReady
+			export.FileName = fileName
+			export.ExpiresAt = &expiresAt
+			return nil
+		})
+		if buildErr != nil {
+			fmt.Printf("Error building export %d: %v\n", exportID, buildErr)
+		}
+		if err != nil {
+			fmt.Printf("Error writing database: %v\n", err)
+		}
+	}()
+}
+
+// resumeExports restarts builds that were cut short by a restart.
+func (cfg *apiConfig) resumeExports() {
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+
+	exports, err := db.GetPendingExports()
+	if err != nil {
+		fmt.Printf("Error loading pending exports: %v\n", err)
+		return
+	}
+
+	for _, export := range exports {
+		cfg.runExport(export.Id)
+	}
+}
+
+func (cfg *apiConfig) buildExport(db *database.DB, exportID int) (string, error) {
+	export, err := db.GetExport(exportID)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := db.CollectUserData(export.UserId)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(cfg.exportDir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	suffix, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	fileName := fmt.Sprintf("export-%d-%s.zip", exportID, suffix[:16])
+	tmpPath := filepath.Join(cfg.exportDir, fileName+".tmp")
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpPath)
+
+	archive := zip.NewWriter(file)
+	err = cfg.writeExportArchive(archive, data)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmpPath, filepath.Join(cfg.exportDir, fileName))
+	if err != nil {
+		return "", err
+	}
+
+	return fileName, nil
+}
+
+// writeExportArchive only writes data owned by the exporting user. Other users
+// appear by id alone, and the password hash and tokens are left out. Chirpy
+// keeps no chirp revisions, reactions or bookmarks, so there are none to
+// export.
+func (cfg *apiConfig) writeExportArchive(archive *zip.Writer, data *database.UserData) error {
+	user := data.User
+
+	reports := make([]exportedReport, 0, len(data.Reports))
+	for _, report := range data.Reports {
+		reports = append(reports, exportedReport{
+			Id:        report.Id,
+			ChirpId:   report.ChirpId,
+			Reason:    report.Reason,
+			CreatedAt: report.CreatedAt,
+		})
+	}
+
+	files := map[string]interface{}{
+		"profile.json": exportedProfile{
+			Id:          user.Id,
+			Email:       user.Email,
+			Handle:      user.Handle,
+			DisplayName: user.DisplayName,
+			Bio:         user.Bio,
+			AvatarFile:  user.AvatarFile,
+			IsChirpyRed: user.IsChirpyRed,
+			Preferences: withDefaultPreferences(user.Preferences),
+			CreatedAt:   user.CreatedAt,
+		},
+		"chirps.json":  data.Chirps,
+		"reports.json": reports,
+		"relations.json": exportedRelations{
+			Following: data.Following,
+			Followers: data.Followers,
+			Blocked:   data.Blocked,
+			Muted:     data.Muted,
+		},
+	}
+
+	for name, content := range files {
+		entry, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(content)
+		if err != nil {
+			return err
+		}
+	}
+
+	if user.AvatarFile == "" {
+		return nil
+	}
+
+	return cfg.copyMediaIntoArchive(archive, user.AvatarFile)
+}
+
+func (cfg *apiConfig) copyMediaIntoArchive(archive *zip.Writer, name string) error {
+	path, err := cfg.media.Path(name)
+	if err != nil {
+		return err
+	}
+
+	source, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	entry, err := archive.Create("media/" + name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, source)
+	return err
+}
+
+// runExportSweeper deletes the archives of exports that have expired.
+func (cfg *apiConfig) runExportSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cfg.deleteExpiredExports(now)
+		}
+	}
+}
+
+func (cfg *apiConfig) deleteExpiredExports(now time.Time) {
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+
+	exports, err := db.GetExpiredExports(now)
+	if err != nil {
+		fmt.Printf("Error loading expired exports: %v\n", err)
+		return
+	}
+
+	for _, export := range exports {
+		// The file goes first: an export marked expired is never looked at
+		// again, so its file would stay behind.
+		err := os.Remove(filepath.Join(cfg.exportDir, export.FileName))
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Error deleting export %d: %v\n", export.Id, err)
+			continue
+		}
+
+		_, err = db.UpdateExport(export.Id, func(export *models.DataExport) error {
+			export.Status = models.ExportStatusExpired
+			export.FileName = ""
+			export.DownloadTokenHash = ""
+			export.DownloadExpiresAt = nil
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Error writing database: %v\n", err)
+		}
+	}
+}
+
+func (cfg *apiConfig) deleteExportFiles(db *database.DB, userID int) error {
+	exports, err := db.GetExportsOfUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		if export.FileName == "" {
+			continue
+		}
+
+		err := os.Remove(filepath.Join(cfg.exportDir, export.FileName))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ownExport(w http.ResponseWriter, r *http.Request) (*database.DB, *models.DataExport, bool) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return nil, nil, false
+	}
+
+	exportID, err := strconv.Atoi(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "export not found")
+		return nil, nil, false
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	export, err := db.GetExport(exportID)
+	if err != nil || export.UserId != userID {
+		respondWithError(w, http.StatusNotFound, "export not found")
+		return nil, nil, false
+	}
+
+	return db, export, true
+}
+
+func exportResponse(export *models.DataExport, downloadToken string) models.DataExportResponse {
+	response := models.DataExportResponse{
+		Id:          export.Id,
+		Status:      export.Status,
+		StatusURL:   fmt.Sprintf("/api/users/export/%d", export.Id),
+		CreatedAt:   export.CreatedAt,
+		CompletedAt: export.CompletedAt,
+		ExpiresAt:   export.ExpiresAt,
+	}
+
+	if downloadToken != "" {
+		response.DownloadURL = fmt.Sprintf("/api/users/export/%d/download?token=%s", export.Id, downloadToken)
+		response.DownloadExpiresAt = export.DownloadExpiresAt
+	}
+
+	return response
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"Chirpy/models"
+	"time"
+)
+
+func (db *DB) SoftDeleteUser(id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[id]
+		if !ok || user.Deleted() {
+			return ErrNotFound
+		}
+
+		now := time.Now().UTC()
+		user.DeletedAt = &now
+		dbStructure.Users[id] = user
+		revokeSessionsOfUser(dbStructure, id)
+		deleteAPIKeysOfUser(dbStructure, id)
+
+		return nil
+	})
+}
+
+// RestoreUser undoes a soft delete. It fails once the purge has started.
+func (db *DB) RestoreUser(id int) (*models.User, error) {
+	return db.UpdateUser(id, func(user *models.User) error {
+		if user.PurgeStage != "" {
+			return ErrNotFound
+		}
+
+		user.DeletedAt = nil
+		return nil
+	})
+}
+
+// UsersToPurge returns soft-deleted users whose grace period ended before
+// cutoff, plus any user whose purge was interrupted.
+func (db *DB) UsersToPurge(cutoff time.Time) ([]models.User, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	users := []models.User{}
+	for _, user := range loadDB.Users {
+		if !user.Deleted() {
+			continue
+		}
+
+		if user.PurgeStage != "" || user.DeletedAt.Before(cutoff) {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+func (db *DB) DeletedUserIDs() (map[int]bool, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := make(map[int]bool)
+	for id, user := range loadDB.Users {
+		if user.Deleted() {
+			deleted[id] = true
+		}
+	}
+
+	return deleted, nil
+}
+
+func (db *DB) SetPurgeStage(id int, stage string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		return setPurgeStage(dbStructure, id, stage)
+	})
+}
+
+// PurgeChirps deletes every chirp of the user the same way DELETE
+// /api/chirps/{chirpID} does and anonymizes the reports they filed.
+func (db *DB) PurgeChirps(id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for chirpID, chirp := range dbStructure.Chirps {
+			if chirp.AuthorId == id {
+				deleteChirp(dbStructure, chirpID)
+			}
+		}
+
+		for reportID, report := range dbStructure.Reports {
+			if report.ReporterId == id {
+				report.ReporterId = 0
+				dbStructure.Reports[reportID] = report
+			}
+		}
+
+		return setPurgeStage(dbStructure, id, models.PurgeStageRelations)
+	})
+}
+
+// PurgeRelations removes follows, blocks and mutes from and to the user.
+func (db *DB) PurgeRelations(id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for _, relations := range []map[int][]int{dbStructure.Follows, dbStructure.Blocks, dbStructure.Mutes} {
+			delete(relations, id)
+
+			for fromID := range relations {
+				removeID(relations, fromID, id)
+			}
+		}
+
+		return setPurgeStage(dbStructure, id, models.PurgeStageAccount)
+	})
+}
+
+func (db *DB) DeleteUserRecord(id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for exportID, export := range dbStructure.Exports {
+			if export.UserId == id {
+				delete(dbStructure.Exports, exportID)
+			}
+		}
+
+		for challengeID, challenge := range dbStructure.LoginChallenges {
+			if challenge.UserId == id {
+				delete(dbStructure.LoginChallenges, challengeID)
+			}
+		}
+
+		revokeSessionsOfUser(dbStructure, id)
+		deleteAPIKeysOfUser(dbStructure, id)
+		deleteOAuthOfUser(dbStructure, id)
+		delete(dbStructure.Users, id)
+		return nil
+	})
+}
+
+func setPurgeStage(dbStructure *DBStructure, id int, stage string) error {
+	user, ok := dbStructure.Users[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	user.PurgeStage = stage
+	dbStructure.Users[id] = user
+
+	return nil
+}
+
+func (db *DB) SetVerificationToken(id int, tokenHash string, expiresAt time.Time) error {
+	_, err := db.UpdateUser(id, func(user *models.User) error {
+		user.VerificationTokenHash = tokenHash
+		user.VerificationExpiresAt = &expiresAt
+		return nil
+	})
+
+	return err
+}
+
+// VerifyEmail marks the owner of the verification token as verified. The
+// token can be used once.
+func (db *DB) VerifyEmail(tokenHash string) (*models.User, error) {
+	var user models.User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, candidate := range dbStructure.Users {
+			if tokenHash == "" || candidate.VerificationTokenHash != tokenHash {
+				continue
+			}
+
+			if candidate.VerificationExpiresAt == nil || time.Now().After(*candidate.VerificationExpiresAt) {
+				return ErrExpired
+			}
+
+			candidate.EmailVerified = true
+			candidate.VerificationTokenHash = ""
+			candidate.VerificationExpiresAt = nil
+			dbStructure.Users[id] = candidate
+			user = candidate
+
+			return nil
+		}
+
+		return ErrNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"Chirpy/models"
+	"sort"
+	"time"
+)
+
+// apiKeyUseResolution limits how often last_used_at is written, so a busy bot
+// doesn't rewrite the database on every request.
+const apiKeyUseResolution = time.Minute
+
+func (db *DB) CreateAPIKey(key models.APIKey, maxKeys int) (*models.APIKey, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[key.UserId]; !ok {
+			return ErrNotFound
+		}
+
+		count := 0
+		for _, existing := range dbStructure.APIKeys {
+			if existing.UserId == key.UserId {
+				count++
+			}
+		}
+		if count >= maxKeys {
+			return ErrConflict
+		}
+
+		key.Id = nextID(dbStructure.APIKeys)
+		key.CreatedAt = time.Now().UTC()
+		dbStructure.APIKeys[key.Id] = key
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (db *DB) GetAPIKeysOfUser(userID int) ([]models.APIKey, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []models.APIKey{}
+	for _, key := range loadDB.APIKeys {
+		if key.UserId == userID {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Id < keys[j].Id
+	})
+
+	return keys, nil
+}
+
+func (db *DB) RevokeAPIKey(userID int, keyID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		key, ok := dbStructure.APIKeys[keyID]
+		if !ok || key.UserId != userID {
+			return ErrNotFound
+		}
+
+		delete(dbStructure.APIKeys, keyID)
+		return nil
+	})
+}
+
+// UseAPIKey finds a live key by the hash of its secret and records the use.
+func (db *DB) UseAPIKey(secretHash string) (*models.APIKey, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	for _, key := range loadDB.APIKeys {
+		if secretHash == "" || key.SecretHash != secretHash {
+			continue
+		}
+
+		if key.Expired(now) {
+			return nil, ErrExpired
+		}
+
+		if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyUseResolution {
+			err := db.update(func(dbStructure *DBStructure) error {
+				stored, ok := dbStructure.APIKeys[key.Id]
+				if !ok {
+					return ErrNotFound
+				}
+
+				stored.LastUsedAt = &now
+				dbStructure.APIKeys[key.Id] = stored
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			key.LastUsedAt = &now
+		}
+
+		return &key, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+func deleteAPIKeysOfUser(dbStructure *DBStructure, userID int) {
+	for id, key := range dbStructure.APIKeys {
+		if key.UserId == userID {
+			delete(dbStructure.APIKeys, id)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"Chirpy/models"
+	"context"
+	"fmt"
+	"time"
+)
+
+func (db *DB) GetChirpsByAuthor(authorID int) ([]models.Chirp, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	chirps := []models.Chirp{}
+	for _, chirp := range loadDB.Chirps {
+		if chirp.AuthorId == authorID {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	return chirps, nil
+}
+
+func (db *DB) DeleteChirp(id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Chirps[id]; !ok {
+			return ErrNotFound
+		}
+
+		deleteChirp(dbStructure, id)
+		return nil
+	})
+}
+
+func (db *DB) DeleteExpiredChirps(now time.Time) (int, error) {
+	deleted := 0
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, chirp := range dbStructure.Chirps {
+			if chirp.Expired(now) {
+				deleteChirp(dbStructure, id)
+				deleted++
+			}
+		}
+
+		return nil
+	})
+
+	return deleted, err
+}
+
+func (db *DB) RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := db.DeleteExpiredChirps(now)
+			if err != nil {
+				fmt.Printf("Error sweeping expired chirps: %v\n", err)
+			} else if deleted > 0 {
+				fmt.Printf("Expired chirps removed: %d\n", deleted)
+			}
+
+			err = db.DeleteExpiredSessions(now)
+			if err != nil {
+				fmt.Printf("Error sweeping expired sessions: %v\n", err)
+			}
+
+			err = db.DeleteExpiredOAuthCodes(now)
+			if err != nil {
+				fmt.Printf("Error sweeping expired authorization codes: %v\n", err)
+			}
+
+			err = db.DeleteExpiredRevocations(now)
+			if err != nil {
+				fmt.Printf("Error sweeping expired token revocations: %v\n", err)
+			}
+		}
+	}
+}
+
+// deleteChirp removes a chirp and closes its open reports, so an explicit
+// DELETE and an expiry leave the database in the same state. Chirpy has no
+// threads, reactions or bookmarks yet; when they are added, their cleanup
+// belongs here.
+func deleteChirp(dbStructure *DBStructure, id int) {
+	delete(dbStructure.Chirps, id)
+	closeReportsForChirp(dbStructure, id, models.ModerationDelete, "chirp deleted")
+}
@@ -2,11 +2,30 @@ package database
 
 import (
 	"Chirpy/models"
+	"Chirpy/password"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("item not found")
+	ErrConflict = errors.New("item is in a conflicting state")
+	ErrExpired  = errors.New("item has expired")
+)
+
+const (
+	maxContentWarningLength = 100
+
+	legacyExpiresInSeconds = 5184000
+
+	// schemaVersion is raised by migrations that can't tell old records
+	// from new ones by their fields.
+	schemaVersion = 3
 )
 
 type DB struct {
@@ -15,14 +34,149 @@ type DB struct {
 }
 
 type DBStructure struct {
-	Chirps map[int]models.Chirp `json:"chirps"`
-	Users  map[int]models.User  `json:"users"`
+	SchemaVersion int `json:"schema_version"`
+
+	Chirps   map[int]models.Chirp      `json:"chirps"`
+	Users    map[int]models.User       `json:"users"`
+	Reports  map[int]models.Report     `json:"reports"`
+	AuditLog []models.AuditEntry       `json:"audit_log"`
+	Blocks   map[int][]int             `json:"blocks"`
+	Mutes    map[int][]int             `json:"mutes"`
+	Follows  map[int][]int             `json:"follows"`
+	Exports  map[int]models.DataExport `json:"exports"`
+
+	PasswordResets  map[int]models.PasswordReset  `json:"password_resets"`
+	LoginChallenges map[int]models.LoginChallenge `json:"login_challenges"`
+	Sessions        map[int]models.Session        `json:"sessions"`
+	RefreshTokens   map[int]models.RefreshToken   `json:"refresh_tokens"`
+	APIKeys         map[int]models.APIKey         `json:"api_keys"`
+	OAuthClients    map[int]models.OAuthClient    `json:"oauth_clients"`
+	OAuthCodes      map[int]models.OAuthCode      `json:"oauth_codes"`
+	// RevokedTokens maps the jti of revoked access tokens to their expiry.
+	RevokedTokens map[string]time.Time `json:"revoked_tokens"`
+}
+
+// migrate brings records written by older versions up to date. The result
+// is stored with the next write.
+func (dbStructure *DBStructure) migrate() {
+	for id, user := range dbStructure.Users {
+		if user.Role == "" {
+			user.Role = models.RoleUser
+			if user.LegacyModerator {
+				user.Role = models.RoleModerator
+			}
+			user.LegacyModerator = false
+		}
+
+		dbStructure.Users[id] = user
+	}
+
+	if dbStructure.SchemaVersion < 1 {
+		// Accounts from before email verification were never sent a link,
+		// so they keep the access they had.
+		for id, user := range dbStructure.Users {
+			user.EmailVerified = true
+			dbStructure.Users[id] = user
+		}
+	}
+	if dbStructure.SchemaVersion < 2 {
+		for id, user := range dbStructure.Users {
+			user.Email = models.NormalizeEmail(user.Email)
+			dbStructure.Users[id] = user
+		}
+	}
+	if dbStructure.SchemaVersion < 3 {
+		// Every user used to be stored with this lifetime, which was never
+		// applied; now it would be a choice, so it goes back to the default.
+		for id, user := range dbStructure.Users {
+			if user.ExpiresInSeconds == legacyExpiresInSeconds {
+				user.ExpiresInSeconds = 0
+				dbStructure.Users[id] = user
+			}
+		}
+
+		// Exports built before they expired are past any sensible
+		// retention, so the sweeper deletes them right away.
+		for id, export := range dbStructure.Exports {
+			if export.Status == models.ExportStatusReady && export.ExpiresAt == nil {
+				export.ExpiresAt = export.CompletedAt
+				dbStructure.Exports[id] = export
+			}
+		}
+	}
+	dbStructure.SchemaVersion = schemaVersion
+}
+
+func (dbStructure *DBStructure) initMaps() {
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = make(map[int]models.Chirp)
+	}
+	if dbStructure.Users == nil {
+		dbStructure.Users = make(map[int]models.User)
+	}
+	if dbStructure.Reports == nil {
+		dbStructure.Reports = make(map[int]models.Report)
+	}
+	if dbStructure.Blocks == nil {
+		dbStructure.Blocks = make(map[int][]int)
+	}
+	if dbStructure.Mutes == nil {
+		dbStructure.Mutes = make(map[int][]int)
+	}
+	if dbStructure.Follows == nil {
+		dbStructure.Follows = make(map[int][]int)
+	}
+	if dbStructure.Exports == nil {
+		dbStructure.Exports = make(map[int]models.DataExport)
+	}
+	if dbStructure.PasswordResets == nil {
+		dbStructure.PasswordResets = make(map[int]models.PasswordReset)
+	}
+	if dbStructure.LoginChallenges == nil {
+		dbStructure.LoginChallenges = make(map[int]models.LoginChallenge)
+	}
+	if dbStructure.Sessions == nil {
+		dbStructure.Sessions = make(map[int]models.Session)
+	}
+	if dbStructure.RefreshTokens == nil {
+		dbStructure.RefreshTokens = make(map[int]models.RefreshToken)
+	}
+	if dbStructure.APIKeys == nil {
+		dbStructure.APIKeys = make(map[int]models.APIKey)
+	}
+	if dbStructure.OAuthClients == nil {
+		dbStructure.OAuthClients = make(map[int]models.OAuthClient)
+	}
+	if dbStructure.OAuthCodes == nil {
+		dbStructure.OAuthCodes = make(map[int]models.OAuthCode)
+	}
+	if dbStructure.RevokedTokens == nil {
+		dbStructure.RevokedTokens = make(map[string]time.Time)
+	}
+}
+
+var fileLocks = struct {
+	sync.Mutex
+	byPath map[string]*sync.RWMutex
+}{byPath: make(map[string]*sync.RWMutex)}
+
+func lockForPath(path string) *sync.RWMutex {
+	fileLocks.Lock()
+	defer fileLocks.Unlock()
+
+	mux, ok := fileLocks.byPath[path]
+	if !ok {
+		mux = new(sync.RWMutex)
+		fileLocks.byPath[path] = mux
+	}
+
+	return mux
 }
 
 func NewDB(path string) (*DB, error) {
 	db := DB{
 		path: path,
-		mux:  new(sync.RWMutex),
+		mux:  lockForPath(path),
 	}
 
 	err := db.ensureDB()
@@ -46,20 +200,34 @@ func (db *DB) CreateChirp(body string, authorId int) (models.Storable, error) {
 
 	newId := db.generateID(len(loadedDB.Chirps), "chirp")
 
-	db.mux.Lock()
 	chirp, err := unmarshalFunc([]byte(body))
 	if err != nil {
 		return nil, err
 	}
 
-	chirp.(*models.Chirp).AuthorId = authorId
+	typedChirp := chirp.(*models.Chirp)
+	typedChirp.AuthorId = authorId
+	typedChirp.ContentWarning = strings.TrimSpace(typedChirp.ContentWarning)
+	if len(typedChirp.ContentWarning) > maxContentWarningLength {
+		return nil, errors.New("content warning is too long")
+	}
+	if typedChirp.ContentWarning != "" {
+		typedChirp.Sensitive = true
+	}
+	if typedChirp.Expired(time.Now()) {
+		return nil, errors.New("expires_at must be in the future")
+	}
+
+	typedChirp.Hidden = false
+	typedChirp.Held = false
+	typedChirp.CreatedAt = time.Now().UTC()
+
 	chirp.SetId(newId)
-	db.mux.Unlock()
 
 	return chirp, nil
 }
 
-func (db *DB) CreateUser(body string) (models.Storable, *models.UserResponse, error) {
+func (db *DB) CreateUser(body string, hasher password.Hasher) (models.Storable, *models.UserResponse, error) {
 	unmarshalFunc, ok := models.UnmarshalFunc["user"]
 	if !ok {
 		return nil, nil, errors.New("invalid type item")
@@ -81,24 +249,38 @@ func (db *DB) CreateUser(body string) (models.Storable, *models.UserResponse, er
 	newID = db.generateID(len(loadedDB.Users), "user")
 	typedUser := user.(*models.User)
 	db.mux.Lock()
-	typedUser.SetHashPass(typedUser.Password)
-	typedUser.GenerateRefreshToken()
-
-	if typedUser.ExpiresInSeconds == 0 {
-		typedUser.ExpiresInSeconds = 5184000
+	typedUser.Email = models.NormalizeEmail(typedUser.Email)
+	typedUser.Role = models.RoleUser
+	typedUser.LegacyModerator = false
+	typedUser.Suspended = false
+	typedUser.CreatedAt = time.Now().UTC()
+	typedUser.AvatarFile = ""
+	typedUser.DeletedAt = nil
+	typedUser.PurgeStage = ""
+	typedUser.EmailVerified = false
+	typedUser.VerificationTokenHash = ""
+	typedUser.VerificationExpiresAt = nil
+	typedUser.TwoFactorEnabled = false
+	typedUser.TOTPSecret = ""
+	typedUser.PendingTOTPSecret = ""
+	typedUser.TOTPLastCounter = 0
+	typedUser.RecoveryCodeHashes = nil
+	typedUser.ExternalIdentities = nil
+	typedUser.TokensRevokedBefore = nil
+	typedUser.ExpiresInSeconds = 0
+	err = typedUser.SetPassword(typedUser.Password, hasher)
+	if err != nil {
+		db.mux.Unlock()
+		return nil, nil, err
 	}
 	db.mux.Unlock()
 
-	userResponse = models.UserResponse{
-		Id:          newID,
-		Email:       typedUser.Email,
-		IsChirpyRed: typedUser.IsChirpyRed,
-	}
-
 	db.mux.Lock()
 	user.SetId(newID)
 	db.mux.Unlock()
 
+	userResponse = typedUser.Response()
+
 	return user, &userResponse, nil
 }
 
@@ -128,15 +310,8 @@ func (db *DB) UpdateItem(body string, typeItem string, id int) (models.Storable,
 				v.Id = id
 			case *models.User:
 				db.mux.Lock()
-				v.SetHashPass(v.Password)
 				v.Email = newItemWithType.Email
 				v.IsChirpyRed = newItemWithType.IsChirpyRed
-
-				if v.ExpiresInSeconds == 0 {
-					v.ExpiresInSeconds = 5184000
-				} else {
-					v.ExpiresInSeconds = newItemWithType.ExpiresInSeconds
-				}
 				db.mux.Unlock()
 
 				userResponse = models.UserResponse{
@@ -178,12 +353,65 @@ func (db *DB) GetItems(typeItem string) ([]models.Storable, error) {
 	return result, nil
 }
 
+func (db *DB) GetChirp(id int) (*models.Chirp, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	chirp, ok := loadDB.Chirps[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &chirp, nil
+}
+
+func (db *DB) GetUser(id int) (*models.User, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	user, ok := loadDB.Users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &user, nil
+}
+
+// UpdateUser changes a user under the database lock, so concurrent updates
+// don't overwrite each other. update must not call back into db.
+func (db *DB) UpdateUser(id int, update func(user *models.User) error) (*models.User, error) {
+	var user models.User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotFound
+		}
+
+		err := update(&user)
+		if err != nil {
+			return err
+		}
+
+		dbStructure.Users[id] = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 func (db *DB) ensureDB() error {
 	filename := "database.json"
-	emptyDB := DBStructure{
-		Chirps: make(map[int]models.Chirp),
-		Users:  make(map[int]models.User),
-	}
+	emptyDB := DBStructure{SchemaVersion: schemaVersion}
+	emptyDB.initMaps()
 
 	data, err := json.Marshal(&emptyDB)
 	if err != nil {
@@ -211,6 +439,9 @@ func (db *DB) LoadDB() (DBStructure, error) {
 	if err != nil {
 		return DBStructure{}, err
 	}
+
+	dbStructure.initMaps()
+	dbStructure.migrate()
 	return dbStructure, nil
 }
 
@@ -225,42 +456,53 @@ func (db *DB) WriteDB(dbStructure DBStructure, newItem models.Storable) error {
 		dbStructure.Users[db.generateID(len(dbStructure.Users), "user")] = *item
 	}
 
-	data, err := json.Marshal(dbStructure)
+	return db.writeFile(dbStructure)
+}
+
+func (db *DB) update(change func(dbStructure *DBStructure) error) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.LoadDB()
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(db.path, data, 0644)
+	err = change(&dbStructure)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return db.writeFile(dbStructure)
+}
+
+func (db *DB) writeFile(dbStructure DBStructure) error {
+	data, err := json.Marshal(dbStructure)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(db.path, data, 0644)
 }
 
 func (db *DB) generateID(lenItems int, typeId string) int {
 	allItems, err := db.LoadDB()
 	if err != nil {
-		fmt.Errorf("Problem with downloading database %e", err)
+		fmt.Printf("Problem with downloading database %v\n", err)
 	}
 
-	newID := 1
-
-	if typeId == "chirp" {
-		allChirps := allItems.Chirps
+	if typeId == "user" {
+		return nextID(allItems.Users)
+	}
 
-		for chirp := range allChirps {
-			if newID == chirp {
-				newID += 1
-			}
-		}
-	} else if typeId == "user" {
-		allChirps := allItems.Users
+	return nextID(allItems.Chirps)
+}
 
-		for user := range allChirps {
-			if newID == user {
-				newID += 1
-			}
+func nextID[T any](items map[int]T) int {
+	newID := 1
+	for id := range items {
+		if id >= newID {
+			newID = id + 1
 		}
 	}
 
@@ -276,7 +518,7 @@ func (db *DB) EmailValidator(email string) bool {
 	for _, user := range allUsers {
 		switch v := user.(type) {
 		case *models.User:
-			if v.Email == email {
+			if v.Email == models.NormalizeEmail(email) {
 				return false
 			}
 		}
@@ -306,19 +548,3 @@ func (db *DB) DeleteItem(id int, itemType string) DBStructure {
 	}
 	return items
 }
-
-func (db *DB) RevokeRefreshToken(id int) DBStructure {
-	items, err := db.LoadDB()
-	if err != nil {
-		fmt.Println(err)
-	}
-
-	for key, val := range items.Users {
-		if key == id {
-			val.RefreshToken = ""
-			items.Users[key] = val
-		}
-	}
-
-	return items
-}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"Chirpy/models"
+	"Chirpy/password"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestDB opens a fresh database in a temporary working directory, where
+// ensureDB creates it.
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(previous)
+	})
+
+	db, err := NewDB("database.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestCreateUserIgnoresPrivilegedFields(t *testing.T) {
+	db := newTestDB(t)
+	hasher := password.Argon2id{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+
+	body := `{
+		"email": "Walt@Example.com",
+		"password": "Correct-Horse-42",
+		"role": "admin",
+		"suspended": true,
+		"email_verified": true,
+		"two_factor_enabled": true,
+		"deleted_at": "2024-01-01T00:00:00Z",
+		"purge_stage": "chirps"
+	}`
+
+	item, _, err := db.CreateUser(body, hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := item.(*models.User)
+
+	if user.Email != "walt@example.com" {
+		t.Errorf("got email %q, want it normalized", user.Email)
+	}
+	if user.Role != models.RoleUser || user.Suspended || user.EmailVerified || user.TwoFactorEnabled {
+		t.Errorf("got role %q, suspended %v, email verified %v and two-factor %v, want a plain new user",
+			user.Role, user.Suspended, user.EmailVerified, user.TwoFactorEnabled)
+	}
+	if user.DeletedAt != nil || user.PurgeStage != "" {
+		t.Errorf("got deleted_at %v and purge_stage %q, want a live account", user.DeletedAt, user.PurgeStage)
+	}
+}
+
+func TestMigrateResetsLegacyLifetimeOnce(t *testing.T) {
+	completedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	dbStructure := DBStructure{
+		SchemaVersion: 2,
+		Users:         map[int]models.User{1: {Id: 1, Role: models.RoleUser, ExpiresInSeconds: legacyExpiresInSeconds}},
+		Exports:       map[int]models.DataExport{1: {Id: 1, Status: models.ExportStatusReady, CompletedAt: &completedAt}},
+	}
+	dbStructure.migrate()
+
+	if got := dbStructure.Users[1].ExpiresInSeconds; got != 0 {
+		t.Errorf("got expires_in_seconds %d after migrating, want 0", got)
+	}
+	if got := dbStructure.Exports[1].ExpiresAt; got == nil || !got.Equal(completedAt) {
+		t.Errorf("got export expiry %v after migrating, want %v", got, completedAt)
+	}
+
+	// Chosen later, the same lifetime is kept.
+	user := dbStructure.Users[1]
+	user.ExpiresInSeconds = legacyExpiresInSeconds
+	dbStructure.Users[1] = user
+	dbStructure.migrate()
+
+	if got := dbStructure.Users[1].ExpiresInSeconds; got != legacyExpiresInSeconds {
+		t.Errorf("got expires_in_seconds %d after loading again, want %d", got, legacyExpiresInSeconds)
+	}
+}
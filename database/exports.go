@@ -0,0 +1,179 @@
+package database
+
+import (
+	"Chirpy/models"
+	"sort"
+	"time"
+)
+
+// UserData is everything stored about one user, read from a single snapshot
+// of the database. It only holds ids of other users, never their data.
+type UserData struct {
+	User      models.User
+	Chirps    []models.Chirp
+	Reports   []models.Report
+	Following []int
+	Followers []int
+	Blocked   []int
+	Muted     []int
+}
+
+// CreateExport starts a new export for the user, or returns the one that is
+// still being built.
+func (db *DB) CreateExport(userID int) (*models.DataExport, error) {
+	var export models.DataExport
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		for _, existing := range dbStructure.Exports {
+			if existing.UserId == userID && existing.Status == models.ExportStatusPending {
+				export = existing
+				return nil
+			}
+		}
+
+		export = models.DataExport{
+			Id:        nextID(dbStructure.Exports),
+			UserId:    userID,
+			Status:    models.ExportStatusPending,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.Exports[export.Id] = export
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+func (db *DB) GetExport(id int) (*models.DataExport, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	export, ok := loadDB.Exports[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &export, nil
+}
+
+func (db *DB) UpdateExport(id int, change func(export *models.DataExport) error) (*models.DataExport, error) {
+	var export models.DataExport
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		export, ok = dbStructure.Exports[id]
+		if !ok {
+			return ErrNotFound
+		}
+
+		err := change(&export)
+		if err != nil {
+			return err
+		}
+
+		dbStructure.Exports[id] = export
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+func (db *DB) GetExportsOfUser(userID int) ([]models.DataExport, error) {
+	return db.getExports(func(export models.DataExport) bool {
+		return export.UserId == userID
+	})
+}
+
+// GetExpiredExports returns the ready exports whose archive is due for
+// deletion.
+func (db *DB) GetExpiredExports(now time.Time) ([]models.DataExport, error) {
+	return db.getExports(func(export models.DataExport) bool {
+		return export.Status == models.ExportStatusReady && export.ExpiresAt != nil && !now.Before(*export.ExpiresAt)
+	})
+}
+
+func (db *DB) GetPendingExports() ([]models.DataExport, error) {
+	return db.getExports(func(export models.DataExport) bool {
+		return export.Status == models.ExportStatusPending
+	})
+}
+
+func (db *DB) getExports(match func(export models.DataExport) bool) ([]models.DataExport, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	exports := []models.DataExport{}
+	for _, export := range loadDB.Exports {
+		if match(export) {
+			exports = append(exports, export)
+		}
+	}
+
+	sort.Slice(exports, func(i, j int) bool {
+		return exports[i].Id < exports[j].Id
+	})
+
+	return exports, nil
+}
+
+func (db *DB) CollectUserData(userID int) (*UserData, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	user, ok := loadDB.Users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	data := UserData{
+		User:      user,
+		Chirps:    []models.Chirp{},
+		Reports:   []models.Report{},
+		Following: sortedIDs(loadDB.Follows[userID]),
+		Followers: []int{},
+		Blocked:   sortedIDs(loadDB.Blocks[userID]),
+		Muted:     sortedIDs(loadDB.Mutes[userID]),
+	}
+
+	for _, chirp := range loadDB.Chirps {
+		if chirp.AuthorId == userID {
+			data.Chirps = append(data.Chirps, chirp)
+		}
+	}
+	sort.Slice(data.Chirps, func(i, j int) bool {
+		return data.Chirps[i].Id < data.Chirps[j].Id
+	})
+
+	for _, report := range loadDB.Reports {
+		if report.ReporterId == userID {
+			data.Reports = append(data.Reports, report)
+		}
+	}
+	sort.Slice(data.Reports, func(i, j int) bool {
+		return data.Reports[i].Id < data.Reports[j].Id
+	})
+
+	for followerID, followed := range loadDB.Follows {
+		for _, id := range followed {
+			if id == userID {
+				data.Followers = append(data.Followers, followerID)
+			}
+		}
+	}
+	data.Followers = sortedIDs(data.Followers)
+
+	return &data, nil
+}
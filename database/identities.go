@@ -0,0 +1,108 @@
+package database
+
+import (
+	"Chirpy/models"
+	"time"
+)
+
+func (db *DB) GetUserByExternalIdentity(provider string, subject string) (*models.User, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	userID, ok := findExternalIdentity(&loadDB, provider, subject)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	user := loadDB.Users[userID]
+	return &user, nil
+}
+
+// LinkExternalIdentity links an account at provider to the user. An account
+// already linked to someone else is an ErrConflict.
+func (db *DB) LinkExternalIdentity(userID int, provider string, subject string) (*models.User, error) {
+	var user models.User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotFound
+		}
+
+		linkedID, linked := findExternalIdentity(dbStructure, provider, subject)
+		if linked && linkedID != userID {
+			return ErrConflict
+		}
+		if linked {
+			return nil
+		}
+
+		user.ExternalIdentities = append(user.ExternalIdentities, models.ExternalIdentity{
+			Provider: provider,
+			Subject:  subject,
+			LinkedAt: time.Now().UTC(),
+		})
+		dbStructure.Users[userID] = user
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreateExternalUser registers a user who signed in with an OpenID provider
+// for the first time. The email was verified by the provider.
+func (db *DB) CreateExternalUser(email string, passwordHash string, provider string, subject string) (*models.User, error) {
+	var user models.User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		email = models.NormalizeEmail(email)
+		if _, taken := findUserByEmail(dbStructure, email); taken {
+			return ErrConflict
+		}
+		if _, linked := findExternalIdentity(dbStructure, provider, subject); linked {
+			return ErrConflict
+		}
+
+		now := time.Now().UTC()
+		user = models.User{
+			Id:            nextID(dbStructure.Users),
+			Email:         email,
+			Password:      passwordHash,
+			Role:          models.RoleUser,
+			CreatedAt:     now,
+			EmailVerified: true,
+			ExternalIdentities: []models.ExternalIdentity{{
+				Provider: provider,
+				Subject:  subject,
+				LinkedAt: now,
+			}},
+		}
+		dbStructure.Users[user.Id] = user
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func findExternalIdentity(dbStructure *DBStructure, provider string, subject string) (int, bool) {
+	for id, user := range dbStructure.Users {
+		for _, identity := range user.ExternalIdentities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return id, true
+			}
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,26 @@
+package database
+
+import "Chirpy/models"
+
+// UnlockUser records that the login lockout of the user was lifted.
+// The lockout itself is kept in memory by the server.
+func (db *DB) UnlockUser(userID int, actorID int) (*models.User, error) {
+	var user models.User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotFound
+		}
+
+		appendAudit(dbStructure, actorID, "user.unlock", "user", userID, "")
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
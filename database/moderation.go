@@ -0,0 +1,238 @@
+package database
+
+import (
+	"Chirpy/models"
+	"sort"
+	"time"
+)
+
+func (db *DB) CreateReport(chirpID int, reporterID int, reason string) (*models.Report, error) {
+	var report models.Report
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Chirps[chirpID]; !ok {
+			return ErrNotFound
+		}
+
+		for _, existing := range dbStructure.Reports {
+			if existing.ChirpId == chirpID && existing.ReporterId == reporterID && existing.Status != models.ReportStatusResolved {
+				return ErrConflict
+			}
+		}
+
+		now := time.Now().UTC()
+		report = models.Report{
+			Id:         nextID(dbStructure.Reports),
+			ChirpId:    chirpID,
+			ReporterId: reporterID,
+			Reason:     reason,
+			Status:     models.ReportStatusOpen,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		dbStructure.Reports[report.Id] = report
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+func (db *DB) GetReports(status string) ([]models.Report, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []models.Report{}
+	for _, report := range loadDB.Reports {
+		if status == "" || report.Status == status {
+			reports = append(reports, report)
+		}
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Id < reports[j].Id
+	})
+
+	return reports, nil
+}
+
+func (db *DB) ClaimReport(reportID int, moderatorID int) (*models.Report, error) {
+	var report models.Report
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		report, ok = dbStructure.Reports[reportID]
+		if !ok {
+			return ErrNotFound
+		}
+
+		if !canModerate(report, moderatorID) {
+			return ErrConflict
+		}
+
+		report.Status = models.ReportStatusClaimed
+		report.ClaimedBy = moderatorID
+		report.UpdatedAt = time.Now().UTC()
+		dbStructure.Reports[reportID] = report
+
+		appendAudit(dbStructure, moderatorID, "report.claim", "report", reportID, "")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// ResolveReport closes a report with one of the moderation actions. Resolving
+// releases a chirp held by the spam filter; hiding or deleting the chirp also
+// closes every other open report against it.
+func (db *DB) ResolveReport(reportID int, moderatorID int, action string, resolution string) (*models.Report, error) {
+	var report models.Report
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		report, ok = dbStructure.Reports[reportID]
+		if !ok {
+			return ErrNotFound
+		}
+
+		if !canModerate(report, moderatorID) {
+			return ErrConflict
+		}
+
+		report.Status = models.ReportStatusResolved
+		report.ClaimedBy = moderatorID
+		report.Action = action
+		report.Resolution = resolution
+		report.UpdatedAt = time.Now().UTC()
+		dbStructure.Reports[reportID] = report
+
+		switch action {
+		case models.ModerationResolve:
+			chirp, ok := dbStructure.Chirps[report.ChirpId]
+			if ok && chirp.Held {
+				chirp.Held = false
+				dbStructure.Chirps[report.ChirpId] = chirp
+				appendAudit(dbStructure, moderatorID, "chirp.release", "chirp", report.ChirpId, resolution)
+			}
+		case models.ModerationHide:
+			chirp, ok := dbStructure.Chirps[report.ChirpId]
+			if ok {
+				chirp.Hidden = true
+				dbStructure.Chirps[report.ChirpId] = chirp
+				closeReportsForChirp(dbStructure, report.ChirpId, action, resolution)
+				appendAudit(dbStructure, moderatorID, "chirp.hide", "chirp", report.ChirpId, resolution)
+			}
+		case models.ModerationDelete:
+			if _, ok := dbStructure.Chirps[report.ChirpId]; ok {
+				deleteChirp(dbStructure, report.ChirpId)
+				appendAudit(dbStructure, moderatorID, "chirp.delete", "chirp", report.ChirpId, resolution)
+			}
+		}
+
+		appendAudit(dbStructure, moderatorID, "report.resolve", "report", reportID, resolution)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// SetUserSuspended suspends or reinstates a user. Moderators can only act on
+// users whose role is below their own, otherwise it gives ErrConflict.
+func (db *DB) SetUserSuspended(userID int, moderatorID int, suspended bool) (*models.User, error) {
+	var user models.User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotFound
+		}
+
+		moderator, ok := dbStructure.Users[moderatorID]
+		if !ok {
+			return ErrNotFound
+		}
+		if models.RoleAtLeast(user.Role, moderator.Role) {
+			return ErrConflict
+		}
+
+		user.Suspended = suspended
+		dbStructure.Users[userID] = user
+
+		action := "user.unsuspend"
+		if suspended {
+			action = "user.suspend"
+		}
+		appendAudit(dbStructure, moderatorID, action, "user", userID, "")
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (db *DB) GetAuditLog() ([]models.AuditEntry, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	if loadDB.AuditLog == nil {
+		return []models.AuditEntry{}, nil
+	}
+
+	return loadDB.AuditLog, nil
+}
+
+func canModerate(report models.Report, moderatorID int) bool {
+	switch report.Status {
+	case models.ReportStatusOpen:
+		return true
+	case models.ReportStatusClaimed:
+		return report.ClaimedBy == moderatorID
+	default:
+		return false
+	}
+}
+
+func closeReportsForChirp(dbStructure *DBStructure, chirpID int, action string, resolution string) {
+	for id, report := range dbStructure.Reports {
+		if report.ChirpId != chirpID || report.Status == models.ReportStatusResolved {
+			continue
+		}
+
+		report.Status = models.ReportStatusResolved
+		report.Action = action
+		report.Resolution = resolution
+		report.UpdatedAt = time.Now().UTC()
+		dbStructure.Reports[id] = report
+	}
+}
+
+// appendAudit is the only way entries get into the audit log; nothing in this
+// package edits or removes them afterwards.
+func appendAudit(dbStructure *DBStructure, actorID int, action string, targetType string, targetID int, details string) {
+	dbStructure.AuditLog = append(dbStructure.AuditLog, models.AuditEntry{
+		Id:         len(dbStructure.AuditLog) + 1,
+		ActorId:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetID,
+		Details:    details,
+		CreatedAt:  time.Now().UTC(),
+	})
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"Chirpy/models"
+	"sort"
+	"time"
+)
+
+func (db *DB) CreateOAuthClient(client models.OAuthClient, maxClients int) (*models.OAuthClient, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[client.OwnerId]; !ok {
+			return ErrNotFound
+		}
+
+		count := 0
+		for _, existing := range dbStructure.OAuthClients {
+			if existing.OwnerId == client.OwnerId {
+				count++
+			}
+		}
+		if count >= maxClients {
+			return ErrConflict
+		}
+
+		client.Id = nextID(dbStructure.OAuthClients)
+		client.CreatedAt = time.Now().UTC()
+		dbStructure.OAuthClients[client.Id] = client
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (db *DB) GetOAuthClient(clientID string) (*models.OAuthClient, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	_, client, ok := findOAuthClient(&loadDB, clientID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &client, nil
+}
+
+func (db *DB) GetOAuthClientsOfUser(ownerID int) ([]models.OAuthClient, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	clients := []models.OAuthClient{}
+	for _, client := range loadDB.OAuthClients {
+		if client.OwnerId == ownerID {
+			clients = append(clients, client)
+		}
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Id < clients[j].Id
+	})
+
+	return clients, nil
+}
+
+// DeleteOAuthClient removes a client of the owner together with its pending
+// codes and every session users granted it.
+func (db *DB) DeleteOAuthClient(ownerID int, clientID string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		id, client, ok := findOAuthClient(dbStructure, clientID)
+		if !ok || client.OwnerId != ownerID {
+			return ErrNotFound
+		}
+
+		deleteOAuthClient(dbStructure, id)
+		return nil
+	})
+}
+
+func (db *DB) CreateOAuthCode(code models.OAuthCode) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, _, ok := findOAuthClient(dbStructure, code.ClientId); !ok {
+			return ErrNotFound
+		}
+
+		code.Id = nextID(dbStructure.OAuthCodes)
+		dbStructure.OAuthCodes[code.Id] = code
+
+		return nil
+	})
+}
+
+// ExchangeOAuthCode redeems an authorization code issued to clientID for
+// redirectURI, whose PKCE challenge must equal codeChallenge, and opens the
+// session described by session with its first refresh token. A code that was
+// already redeemed revokes the session it opened and gives ErrTokenReused.
+func (db *DB) ExchangeOAuthCode(codeHash string, clientID string, redirectURI string, codeChallenge string, session models.Session, refreshHash string) (*models.Session, error) {
+	reused := false
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var code models.OAuthCode
+		found := false
+		for _, candidate := range dbStructure.OAuthCodes {
+			if codeHash != "" && candidate.CodeHash == codeHash {
+				code, found = candidate, true
+				break
+			}
+		}
+		if !found || code.ClientId != clientID {
+			return ErrNotFound
+		}
+
+		now := time.Now().UTC()
+		if code.UsedAt != nil {
+			deleteSession(dbStructure, code.SessionId)
+			reused = true
+			return nil
+		}
+		if !now.Before(code.ExpiresAt) {
+			return ErrExpired
+		}
+		if code.RedirectURI != redirectURI || code.CodeChallenge != codeChallenge {
+			return ErrNotFound
+		}
+		if _, ok := dbStructure.Users[code.UserId]; !ok {
+			return ErrNotFound
+		}
+
+		session.Id = nextID(dbStructure.Sessions)
+		session.UserId = code.UserId
+		session.Scope = code.Scope
+		session.ClientId = clientID
+		session.CreatedAt = now
+		session.LastUsedAt = now
+		dbStructure.Sessions[session.Id] = session
+
+		addRefreshToken(dbStructure, session.Id, refreshHash, now, session.ExpiresAt)
+
+		code.UsedAt = &now
+		code.SessionId = session.Id
+		dbStructure.OAuthCodes[code.Id] = code
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrTokenReused
+	}
+
+	return &session, nil
+}
+
+func (db *DB) DeleteExpiredOAuthCodes(now time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for id, code := range dbStructure.OAuthCodes {
+			if !now.Before(code.ExpiresAt) {
+				delete(dbStructure.OAuthCodes, id)
+			}
+		}
+
+		return nil
+	})
+}
+
+func findOAuthClient(dbStructure *DBStructure, clientID string) (int, models.OAuthClient, bool) {
+	for id, client := range dbStructure.OAuthClients {
+		if clientID != "" && client.ClientId == clientID {
+			return id, client, true
+		}
+	}
+
+	return 0, models.OAuthClient{}, false
+}
+
+func deleteOAuthClient(dbStructure *DBStructure, id int) {
+	clientID := dbStructure.OAuthClients[id].ClientId
+
+	for codeID, code := range dbStructure.OAuthCodes {
+		if code.ClientId == clientID {
+			delete(dbStructure.OAuthCodes, codeID)
+		}
+	}
+
+	for sessionID, session := range dbStructure.Sessions {
+		if session.ClientId == clientID {
+			deleteSession(dbStructure, sessionID)
+		}
+	}
+
+	delete(dbStructure.OAuthClients, id)
+}
+
+// deleteOAuthOfUser removes the user's pending codes and the clients the user
+// registered.
+func deleteOAuthOfUser(dbStructure *DBStructure, userID int) {
+	for codeID, code := range dbStructure.OAuthCodes {
+		if code.UserId == userID {
+			delete(dbStructure.OAuthCodes, codeID)
+		}
+	}
+
+	for id, client := range dbStructure.OAuthClients {
+		if client.OwnerId == userID {
+			deleteOAuthClient(dbStructure, id)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"Chirpy/models"
+	"Chirpy/password"
+	"time"
+)
+
+func (db *DB) GetUserByEmail(email string) (*models.User, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	user, ok := findUserByEmail(&loadDB, email)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &user, nil
+}
+
+// findUserByEmail compares normalized emails. Databases from before emails
+// were normalized may hold the same address twice; the older account wins,
+// so the answer doesn't depend on map order.
+func findUserByEmail(dbStructure *DBStructure, email string) (models.User, bool) {
+	email = models.NormalizeEmail(email)
+
+	var found models.User
+	ok := false
+	for _, user := range dbStructure.Users {
+		if user.Email == email && (!ok || user.Id < found.Id) {
+			found, ok = user, true
+		}
+	}
+
+	return found, ok
+}
+
+// CreatePasswordReset stores a new reset token for the user and retires the
+// ones issued before it, so only the latest email works.
+func (db *DB) CreatePasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+
+		for id, reset := range dbStructure.PasswordResets {
+			if reset.UserId == userID && reset.UsedAt == nil {
+				reset.UsedAt = &now
+				dbStructure.PasswordResets[id] = reset
+			}
+		}
+
+		id := nextID(dbStructure.PasswordResets)
+		dbStructure.PasswordResets[id] = models.PasswordReset{
+			Id:        id,
+			UserId:    userID,
+			TokenHash: tokenHash,
+			ExpiresAt: expiresAt,
+			CreatedAt: now,
+		}
+
+		return nil
+	})
+}
+
+// ResetPassword spends a reset token and gives its user the new password,
+// once check accepts it for that user. Sessions started before the reset are
+// revoked; lifting a lockout from failed logins is up to the caller, which
+// keeps them in memory.
+func (db *DB) ResetPassword(tokenHash string, plain string, hasher password.Hasher, check func(user *models.User) error) (*models.User, error) {
+	var user models.User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, reset := range dbStructure.PasswordResets {
+			if tokenHash == "" || reset.TokenHash != tokenHash {
+				continue
+			}
+
+			now := time.Now().UTC()
+			if reset.UsedAt != nil || now.After(reset.ExpiresAt) {
+				return ErrExpired
+			}
+
+			var ok bool
+			user, ok = dbStructure.Users[reset.UserId]
+			if !ok {
+				return ErrNotFound
+			}
+
+			err := check(&user)
+			if err != nil {
+				return err
+			}
+
+			err = user.SetPassword(plain, hasher)
+			if err != nil {
+				return err
+			}
+			dbStructure.Users[user.Id] = user
+			revokeSessionsOfUser(dbStructure, user.Id)
+
+			reset.UsedAt = &now
+			dbStructure.PasswordResets[id] = reset
+
+			return nil
+		}
+
+		return ErrNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"Chirpy/models"
+	"strings"
+	"time"
+)
+
+type ProfileStats struct {
+	FollowerCount  int
+	FollowingCount int
+	ChirpCount     int
+}
+
+func (db *DB) GetUserByHandle(handle string) (*models.User, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range loadDB.Users {
+		if user.Handle != "" && strings.EqualFold(user.Handle, handle) {
+			return &user, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// HandleAvailable reports whether no user other than userID has the handle.
+// Handles are compared case-insensitively.
+func (db *DB) HandleAvailable(handle string, userID int) bool {
+	existing, err := db.GetUserByHandle(handle)
+	if err != nil {
+		return true
+	}
+
+	return existing.Id == userID
+}
+
+func (db *DB) GetProfileStats(userID int) (ProfileStats, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return ProfileStats{}, err
+	}
+
+	stats := ProfileStats{
+		FollowingCount: len(loadDB.Follows[userID]),
+	}
+
+	for _, followed := range loadDB.Follows {
+		for _, id := range followed {
+			if id == userID {
+				stats.FollowerCount++
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, chirp := range loadDB.Chirps {
+		if chirp.AuthorId == userID && !chirp.Hidden && !chirp.Held && !chirp.Expired(now) {
+			stats.ChirpCount++
+		}
+	}
+
+	return stats, nil
+}
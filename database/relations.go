@@ -0,0 +1,156 @@
+package database
+
+import (
+	"errors"
+	"slices"
+)
+
+var ErrSelfRelation = errors.New("users can't block, mute or follow themselves")
+
+var ErrBlocked = errors.New("one of the users has blocked the other")
+
+// Block also drops any follow between the two users, in both directions.
+func (db *DB) Block(blockerID int, blockedID int) error {
+	err := db.addRelation(func(dbStructure *DBStructure) map[int][]int { return dbStructure.Blocks }, blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+
+	return db.update(func(dbStructure *DBStructure) error {
+		removeID(dbStructure.Follows, blockerID, blockedID)
+		removeID(dbStructure.Follows, blockedID, blockerID)
+		return nil
+	})
+}
+
+func (db *DB) Unblock(blockerID int, blockedID int) error {
+	return db.removeRelation(func(dbStructure *DBStructure) map[int][]int { return dbStructure.Blocks }, blockerID, blockedID)
+}
+
+func (db *DB) Mute(muterID int, mutedID int) error {
+	return db.addRelation(func(dbStructure *DBStructure) map[int][]int { return dbStructure.Mutes }, muterID, mutedID)
+}
+
+func (db *DB) Unmute(muterID int, mutedID int) error {
+	return db.removeRelation(func(dbStructure *DBStructure) map[int][]int { return dbStructure.Mutes }, muterID, mutedID)
+}
+
+func (db *DB) Follow(followerID int, followedID int) error {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	if isBlockedEitherWay(&loadDB, followerID, followedID) {
+		return ErrBlocked
+	}
+
+	return db.addRelation(func(dbStructure *DBStructure) map[int][]int { return dbStructure.Follows }, followerID, followedID)
+}
+
+func (db *DB) Unfollow(followerID int, followedID int) error {
+	return db.removeRelation(func(dbStructure *DBStructure) map[int][]int { return dbStructure.Follows }, followerID, followedID)
+}
+
+// IsBlockedEitherWay reports whether one of the two users has blocked the other.
+func (db *DB) IsBlockedEitherWay(firstID int, secondID int) (bool, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return false, err
+	}
+
+	return isBlockedEitherWay(&loadDB, firstID, secondID), nil
+}
+
+func (db *DB) GetBlocked(blockerID int) ([]int, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedIDs(loadDB.Blocks[blockerID]), nil
+}
+
+func (db *DB) GetMuted(muterID int) ([]int, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedIDs(loadDB.Mutes[muterID]), nil
+}
+
+// HiddenAuthors returns the authors whose chirps the viewer must not see
+// because of a block in either direction, and the authors the viewer muted.
+func (db *DB) HiddenAuthors(viewerID int) (map[int]bool, map[int]bool, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blocked := make(map[int]bool)
+	for _, id := range loadDB.Blocks[viewerID] {
+		blocked[id] = true
+	}
+	for blockerID, blockedIDs := range loadDB.Blocks {
+		if slices.Contains(blockedIDs, viewerID) {
+			blocked[blockerID] = true
+		}
+	}
+
+	muted := make(map[int]bool)
+	for _, id := range loadDB.Mutes[viewerID] {
+		muted[id] = true
+	}
+
+	return blocked, muted, nil
+}
+
+func (db *DB) addRelation(relations func(dbStructure *DBStructure) map[int][]int, fromID int, toID int) error {
+	if fromID == toID {
+		return ErrSelfRelation
+	}
+
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[toID]; !ok {
+			return ErrNotFound
+		}
+
+		byUser := relations(dbStructure)
+		if !slices.Contains(byUser[fromID], toID) {
+			byUser[fromID] = append(byUser[fromID], toID)
+		}
+
+		return nil
+	})
+}
+
+func (db *DB) removeRelation(relations func(dbStructure *DBStructure) map[int][]int, fromID int, toID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		removeID(relations(dbStructure), fromID, toID)
+		return nil
+	})
+}
+
+func removeID(byUser map[int][]int, fromID int, toID int) {
+	byUser[fromID] = slices.DeleteFunc(byUser[fromID], func(id int) bool {
+		return id == toID
+	})
+	if len(byUser[fromID]) == 0 {
+		delete(byUser, fromID)
+	}
+}
+
+func isBlockedEitherWay(dbStructure *DBStructure, firstID int, secondID int) bool {
+	return slices.Contains(dbStructure.Blocks[firstID], secondID) || slices.Contains(dbStructure.Blocks[secondID], firstID)
+}
+
+func sortedIDs(ids []int) []int {
+	sorted := slices.Clone(ids)
+	if sorted == nil {
+		sorted = []int{}
+	}
+
+	slices.Sort(sorted)
+	return sorted
+}
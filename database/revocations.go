@@ -0,0 +1,59 @@
+package database
+
+import "time"
+
+// DenyToken revokes one access token by its jti. The entry is kept until the
+// token would have expired anyway.
+func (db *DB) DenyToken(jti string, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		dbStructure.RevokedTokens[jti] = expiresAt.UTC()
+		return nil
+	})
+}
+
+// RevokeTokensOfUser revokes every access token of the user issued before
+// before.
+func (db *DB) RevokeTokensOfUser(userID int, before time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[userID]
+		if !ok {
+			return ErrNotFound
+		}
+
+		before = before.UTC()
+		user.TokensRevokedBefore = &before
+		dbStructure.Users[userID] = user
+
+		return nil
+	})
+}
+
+// GetRevocations returns the revoked jtis with their expiry, and the
+// revocation time of every user who has one.
+func (db *DB) GetRevocations() (map[string]time.Time, map[int]time.Time, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	revokedBefore := make(map[int]time.Time)
+	for id, user := range loadDB.Users {
+		if user.TokensRevokedBefore != nil {
+			revokedBefore[id] = *user.TokensRevokedBefore
+		}
+	}
+
+	return loadDB.RevokedTokens, revokedBefore, nil
+}
+
+func (db *DB) DeleteExpiredRevocations(now time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for jti, expiresAt := range dbStructure.RevokedTokens {
+			if !now.Before(expiresAt) {
+				delete(dbStructure.RevokedTokens, jti)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"Chirpy/models"
+	"fmt"
+)
+
+// SetUserRole changes the role of a user. Admins can't change their own role,
+// so the last admin can't lock everyone out by accident.
+func (db *DB) SetUserRole(userID int, role string, actorID int) (*models.User, error) {
+	var user models.User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotFound
+		}
+		if userID == actorID {
+			return ErrConflict
+		}
+
+		if user.Role != role {
+			appendAudit(dbStructure, actorID, "user.role", "user", userID, fmt.Sprintf("%s -> %s", user.Role, role))
+		}
+
+		user.Role = role
+		dbStructure.Users[userID] = user
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// BootstrapAdmin makes the registered user with the given email the first
+// admin. Once there is an admin it gives ErrConflict; further admins are
+// appointed through the API.
+func (db *DB) BootstrapAdmin(email string) (*models.User, error) {
+	var user models.User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		for _, candidate := range dbStructure.Users {
+			if candidate.Role == models.RoleAdmin {
+				return ErrConflict
+			}
+		}
+
+		var found bool
+		user, found = findUserByEmail(dbStructure, email)
+		if !found || user.Deleted() {
+			return ErrNotFound
+		}
+
+		appendAudit(dbStructure, 0, "user.role", "user", user.Id, fmt.Sprintf("%s -> %s (bootstrap)", user.Role, models.RoleAdmin))
+
+		user.Role = models.RoleAdmin
+		dbStructure.Users[user.Id] = user
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// RecordAudit logs an admin action that changes nothing in the database,
+// such as resetting the metrics.
+func (db *DB) RecordAudit(actorID int, action string, targetType string, targetID int, details string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		appendAudit(dbStructure, actorID, action, targetType, targetID, details)
+		return nil
+	})
+}
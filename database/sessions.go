@@ -0,0 +1,207 @@
+package database
+
+import (
+	"Chirpy/models"
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrTokenReused means a refresh token that was already rotated came back.
+// Either the client or an attacker holds a stolen copy, so the session it
+// belongs to has been revoked.
+var ErrTokenReused = errors.New("refresh token was already used")
+
+// CreateSession opens a session together with its first refresh token.
+func (db *DB) CreateSession(session models.Session, tokenHash string) (*models.Session, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[session.UserId]; !ok {
+			return ErrNotFound
+		}
+
+		now := time.Now().UTC()
+		session.Id = nextID(dbStructure.Sessions)
+		session.CreatedAt = now
+		session.LastUsedAt = now
+		dbStructure.Sessions[session.Id] = session
+
+		addRefreshToken(dbStructure, session.Id, tokenHash, now, session.ExpiresAt)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// RotateRefreshToken exchanges a live refresh token for newHash, which
+// expires at expiresAt, and records where the session was used from. The
+// session must belong to clientID, which is empty for our own logins.
+func (db *DB) RotateRefreshToken(oldHash string, clientID string, newHash string, expiresAt time.Time, ip string, userAgent string) (*models.Session, error) {
+	var session models.Session
+	reused := false
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		tokenID, token, ok := findRefreshToken(dbStructure, oldHash)
+		if !ok || dbStructure.Sessions[token.SessionId].ClientId != clientID {
+			return ErrNotFound
+		}
+
+		now := time.Now().UTC()
+		if token.RotatedAt != nil {
+			// Returning nil keeps the revocation; the caller still gets
+			// ErrTokenReused below.
+			deleteSession(dbStructure, token.SessionId)
+			reused = true
+			return nil
+		}
+		if !now.Before(token.ExpiresAt) {
+			return ErrExpired
+		}
+
+		session, ok = dbStructure.Sessions[token.SessionId]
+		if !ok {
+			return ErrNotFound
+		}
+
+		token.RotatedAt = &now
+		dbStructure.RefreshTokens[tokenID] = token
+
+		// Rotated tokens are kept until they would have expired anyway, so a
+		// replay is recognised for as long as it could have worked.
+		for id, previous := range dbStructure.RefreshTokens {
+			if previous.SessionId == session.Id && !now.Before(previous.ExpiresAt) {
+				delete(dbStructure.RefreshTokens, id)
+			}
+		}
+
+		addRefreshToken(dbStructure, session.Id, newHash, now, expiresAt)
+
+		session.IP = ip
+		session.UserAgent = userAgent
+		session.LastUsedAt = now
+		session.ExpiresAt = expiresAt
+		dbStructure.Sessions[session.Id] = session
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrTokenReused
+	}
+
+	return &session, nil
+}
+
+// GetSessionsOfUser returns the user's live sessions, most recently used
+// first.
+func (db *DB) GetSessionsOfUser(userID int) ([]models.Session, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	sessions := []models.Session{}
+	for _, session := range loadDB.Sessions {
+		if session.UserId == userID && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+// RevokeSession ends one session of the user. Sessions of other users are
+// reported as not found.
+func (db *DB) RevokeSession(userID int, sessionID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		session, ok := dbStructure.Sessions[sessionID]
+		if !ok || session.UserId != userID {
+			return ErrNotFound
+		}
+
+		deleteSession(dbStructure, sessionID)
+		return nil
+	})
+}
+
+// RevokeSessionByToken ends the session a refresh token of clientID belongs
+// to, whether or not the token was rotated since.
+func (db *DB) RevokeSessionByToken(tokenHash string, clientID string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		_, token, ok := findRefreshToken(dbStructure, tokenHash)
+		if !ok || dbStructure.Sessions[token.SessionId].ClientId != clientID {
+			return ErrNotFound
+		}
+
+		deleteSession(dbStructure, token.SessionId)
+		return nil
+	})
+}
+
+func (db *DB) RevokeSessionsOfUser(userID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		revokeSessionsOfUser(dbStructure, userID)
+		return nil
+	})
+}
+
+func (db *DB) DeleteExpiredSessions(now time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for id, session := range dbStructure.Sessions {
+			if !now.Before(session.ExpiresAt) {
+				deleteSession(dbStructure, id)
+			}
+		}
+
+		return nil
+	})
+}
+
+func addRefreshToken(dbStructure *DBStructure, sessionID int, tokenHash string, now time.Time, expiresAt time.Time) {
+	id := nextID(dbStructure.RefreshTokens)
+	dbStructure.RefreshTokens[id] = models.RefreshToken{
+		Id:        id,
+		SessionId: sessionID,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+}
+
+func findRefreshToken(dbStructure *DBStructure, tokenHash string) (int, models.RefreshToken, bool) {
+	for id, token := range dbStructure.RefreshTokens {
+		if tokenHash != "" && token.TokenHash == tokenHash {
+			return id, token, true
+		}
+	}
+
+	return 0, models.RefreshToken{}, false
+}
+
+func deleteSession(dbStructure *DBStructure, sessionID int) {
+	for id, token := range dbStructure.RefreshTokens {
+		if token.SessionId == sessionID {
+			delete(dbStructure.RefreshTokens, id)
+		}
+	}
+
+	delete(dbStructure.Sessions, sessionID)
+}
+
+func revokeSessionsOfUser(dbStructure *DBStructure, userID int) {
+	for id, session := range dbStructure.Sessions {
+		if session.UserId == userID {
+			deleteSession(dbStructure, id)
+		}
+	}
+}
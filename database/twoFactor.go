@@ -0,0 +1,172 @@
+package database
+
+import (
+	"Chirpy/models"
+	"time"
+)
+
+// SetPendingTOTPSecret starts an enrollment. The secret only becomes active
+// once a code made from it is confirmed.
+func (db *DB) SetPendingTOTPSecret(id int, secret string) error {
+	_, err := db.UpdateUser(id, func(user *models.User) error {
+		if user.TwoFactorEnabled {
+			return ErrConflict
+		}
+
+		user.PendingTOTPSecret = secret
+		return nil
+	})
+
+	return err
+}
+
+func (db *DB) EnableTwoFactor(id int, secret string, counter int64, recoveryCodeHashes []string) error {
+	_, err := db.UpdateUser(id, func(user *models.User) error {
+		if user.TwoFactorEnabled || user.PendingTOTPSecret != secret {
+			return ErrConflict
+		}
+
+		user.TwoFactorEnabled = true
+		user.TOTPSecret = secret
+		user.PendingTOTPSecret = ""
+		user.TOTPLastCounter = counter
+		user.RecoveryCodeHashes = recoveryCodeHashes
+		return nil
+	})
+
+	return err
+}
+
+func (db *DB) DisableTwoFactor(id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[id]
+		if !ok {
+			return ErrNotFound
+		}
+
+		user.TwoFactorEnabled = false
+		user.TOTPSecret = ""
+		user.PendingTOTPSecret = ""
+		user.TOTPLastCounter = 0
+		user.RecoveryCodeHashes = nil
+		dbStructure.Users[id] = user
+
+		for challengeID, challenge := range dbStructure.LoginChallenges {
+			if challenge.UserId == id {
+				delete(dbStructure.LoginChallenges, challengeID)
+			}
+		}
+
+		return nil
+	})
+}
+
+// UseTOTPCounter records the time step of an accepted code. Two requests
+// racing with the same code can't both get past it.
+func (db *DB) UseTOTPCounter(id int, counter int64) error {
+	_, err := db.UpdateUser(id, func(user *models.User) error {
+		if counter <= user.TOTPLastCounter {
+			return ErrConflict
+		}
+
+		user.TOTPLastCounter = counter
+		return nil
+	})
+
+	return err
+}
+
+// UseRecoveryCode removes a recovery code so it works only once.
+func (db *DB) UseRecoveryCode(id int, codeHash string) error {
+	_, err := db.UpdateUser(id, func(user *models.User) error {
+		for i, hash := range user.RecoveryCodeHashes {
+			if hash == codeHash {
+				user.RecoveryCodeHashes = append(user.RecoveryCodeHashes[:i:i], user.RecoveryCodeHashes[i+1:]...)
+				return nil
+			}
+		}
+
+		return ErrNotFound
+	})
+
+	return err
+}
+
+func (db *DB) CreateLoginChallenge(userID int, tokenHash string, expiresAt time.Time, device string, scope string, cookie bool) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+
+		for id, challenge := range dbStructure.LoginChallenges {
+			if now.After(challenge.ExpiresAt) {
+				delete(dbStructure.LoginChallenges, id)
+			}
+		}
+
+		id := nextID(dbStructure.LoginChallenges)
+		dbStructure.LoginChallenges[id] = models.LoginChallenge{
+			Id:        id,
+			UserId:    userID,
+			TokenHash: tokenHash,
+			ExpiresAt: expiresAt,
+			Device:    device,
+			Scope:     scope,
+			Cookie:    cookie,
+		}
+
+		return nil
+	})
+}
+
+func (db *DB) GetLoginChallenge(tokenHash string) (*models.LoginChallenge, error) {
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, challenge := range loadDB.LoginChallenges {
+		if tokenHash == "" || challenge.TokenHash != tokenHash {
+			continue
+		}
+
+		if time.Now().UTC().After(challenge.ExpiresAt) {
+			return nil, ErrExpired
+		}
+
+		return &challenge, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+// FailLoginChallenge counts a wrong code and drops the challenge once
+// maxAttempts is reached, so the password has to be entered again.
+func (db *DB) FailLoginChallenge(id int, maxAttempts int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		challenge, ok := dbStructure.LoginChallenges[id]
+		if !ok {
+			return ErrNotFound
+		}
+
+		challenge.Attempts++
+		if challenge.Attempts >= maxAttempts {
+			delete(dbStructure.LoginChallenges, id)
+			return nil
+		}
+
+		dbStructure.LoginChallenges[id] = challenge
+		return nil
+	})
+}
+
+// DeleteLoginChallenge spends a challenge. It returns ErrNotFound when
+// another request already spent it.
+func (db *DB) DeleteLoginChallenge(id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.LoginChallenges[id]; !ok {
+			return ErrNotFound
+		}
+
+		delete(dbStructure.LoginChallenges, id)
+		return nil
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/mailer"
+	"Chirpy/models"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"time"
+)
+
+const verificationTokenTTL = 24 * time.Hour
+
+func newMailerFromEnv() (mailer.Mailer, error) {
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		logFile := os.Getenv("MAILER_LOG_FILE")
+		if logFile == "" {
+			return mailer.NewLogMailer(os.Stdout), nil
+		}
+
+		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		return mailer.NewLogMailer(file), nil
+	case "memory":
+		return mailer.NewMemoryMailer(), nil
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_ADDR %q: %w", addr, err)
+		}
+
+		smtpMailer := &mailer.SMTPMailer{
+			Addr: addr,
+			From: os.Getenv("MAIL_FROM"),
+		}
+		if username := os.Getenv("SMTP_USERNAME"); username != "" {
+			smtpMailer.Auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+		}
+
+		return smtpMailer, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", os.Getenv("MAILER"))
+	}
+}
+
+func (cfg *apiConfig) sendVerificationEmail(db *database.DB, user *models.User) error {
+	token, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+
+	err = db.SetVerificationToken(user.Id, hashToken(token), time.Now().Add(verificationTokenTTL).UTC())
+	if err != nil {
+		return err
+	}
+
+	link := cfg.baseURL + "/api/users/verify?token=" + url.QueryEscape(token)
+
+	return cfg.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your Chirpy email address",
+		Body:    "Open this link within 24 hours to confirm your email address:\n\n" + link,
+	})
+}
+
+func (cfg *apiConfig) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.VerifyEmail(hashToken(r.URL.Query().Get("token")))
+	if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrExpired) {
+		respondWithError(w, http.StatusBadRequest, "Verification link is invalid or has expired")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error writing database")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user.Response())
+}
+
+func (cfg *apiConfig) resendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	if user.EmailVerified {
+		respondWithError(w, http.StatusConflict, "Email address is already verified")
+		return
+	}
+
+	err = cfg.sendVerificationEmail(db, user)
+	if err != nil {
+		fmt.Printf("Error sending verification email: %v\n", err)
+		respondWithError(w, http.StatusBadGateway, "Error sending verification email")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
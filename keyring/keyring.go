@@ -0,0 +1,306 @@
+// Package keyring holds the Ed25519 keys access tokens are signed with.
+//
+// Keys live in one directory, one PEM file per key, and the file name without
+// extension is the key id (kid):
+//
+//	<kid>.key   PKCS#8 private key, can sign and verify
+//	<kid>.pub   PKIX public key of a retired key, can only verify
+//	active      the kid new tokens are signed with
+//
+// To rotate, add a new .key file and reload, which publishes its public key.
+// Once verifiers have had time to fetch it, point active at it and reload
+// again. Tokens signed with the old key keep verifying for as long as its
+// file stays in the directory.
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const activeFile = "active"
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	kidPattern    = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+type Keyring struct {
+	dir string
+
+	mu        sync.RWMutex
+	activeKid string
+	signer    ed25519.PrivateKey
+	public    map[string]ed25519.PublicKey
+}
+
+// JWK is the JSON Web Key form of an Ed25519 public key (RFC 8037).
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Load reads the keys in dir. An empty or missing directory gets a freshly
+// generated key, so a development setup works without any preparation.
+func Load(dir string) (*Keyring, error) {
+	k := &Keyring{dir: dir}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		// Nobody can have tokens from a key that never existed, so there
+		// is nothing to wait for.
+		kid, err := Generate(dir, "")
+		if err != nil {
+			return nil, err
+		}
+
+		err = Activate(dir, kid, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return k, k.Reload()
+}
+
+// Reload rereads the directory. On error the keys loaded before stay in use.
+func (k *Keyring) Reload() error {
+	entries, err := os.ReadDir(k.dir)
+	if err != nil {
+		return err
+	}
+
+	public := make(map[string]ed25519.PublicKey)
+	private := make(map[string]ed25519.PrivateKey)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		kid := strings.TrimSuffix(name, ext)
+		if entry.IsDir() || (ext != ".key" && ext != ".pub") {
+			continue
+		}
+		if !kidPattern.MatchString(kid) {
+			return fmt.Errorf("invalid key id %q", kid)
+		}
+
+		data, err := os.ReadFile(filepath.Join(k.dir, name))
+		if err != nil {
+			return err
+		}
+
+		if ext == ".key" {
+			key, err := parsePrivateKey(data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			private[kid] = key
+			public[kid] = key.Public().(ed25519.PublicKey)
+			continue
+		}
+
+		if _, ok := public[kid]; ok {
+			continue
+		}
+		key, err := parsePublicKey(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		public[kid] = key
+	}
+
+	activeKid, err := readActive(k.dir, private)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.activeKid = activeKid
+	k.signer = private[activeKid]
+	k.public = public
+
+	return nil
+}
+
+// Signer returns the active key and its kid.
+func (k *Keyring) Signer() (string, ed25519.PrivateKey) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.activeKid, k.signer
+}
+
+func (k *Keyring) PublicKey(kid string) (ed25519.PublicKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.public[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+// JWKS lists every key that tokens may still be signed with, sorted by kid.
+func (k *Keyring) JWKS() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(k.public))}
+	for kid, key := range k.public {
+		set.Keys = append(set.Keys, JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key),
+			Kid: kid,
+			Use: "sig",
+			Alg: "EdDSA",
+		})
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
+
+// Generate writes a new private key to dir without activating it. An empty
+// kid is derived from the current time.
+func Generate(dir string, kid string) (string, error) {
+	if kid == "" {
+		kid = time.Now().UTC().Format("20060102T150405Z")
+	}
+	if !kidPattern.MatchString(kid) {
+		return "", fmt.Errorf("invalid key id %q", kid)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	err = os.WriteFile(filepath.Join(dir, kid+".key"), data, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	return kid, nil
+}
+
+// Activate makes kid the key new tokens are signed with. Verifiers only
+// accept tokens of keys they have fetched, so the key file has to be at least
+// minAge old, the time a published key set may be cached.
+func Activate(dir string, kid string, minAge time.Duration) error {
+	if !kidPattern.MatchString(kid) {
+		return fmt.Errorf("invalid key id %q", kid)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, kid+".key"))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrUnknownKey
+	}
+	if err != nil {
+		return err
+	}
+
+	if age := time.Since(info.ModTime()); age < minAge {
+		return fmt.Errorf("key %s is only %v old, activate it after %v", kid, age.Round(time.Second), minAge)
+	}
+
+	return os.WriteFile(filepath.Join(dir, activeFile), []byte(kid+"\n"), 0600)
+}
+
+func readActive(dir string, private map[string]ed25519.PrivateKey) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, activeFile))
+	if errors.Is(err, os.ErrNotExist) && len(private) == 1 {
+		for kid := range private {
+			return kid, nil
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("no active key: %w", err)
+	}
+
+	kid := strings.TrimSpace(string(data))
+	if _, ok := private[kid]; !ok {
+		return "", fmt.Errorf("active key %q has no private key file", kid)
+	}
+
+	return kid, nil
+}
+
+func parsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 key")
+	}
+
+	return edKey, nil
+}
+
+func parsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 key")
+	}
+
+	return edKey, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"Chirpy/password"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Device   string `json:"device"`
+	Scope    string `json:"scope"`
+	// Cookie asks for cookie mode, for browsers.
+	Cookie bool `json:"cookie"`
+}
+
+// login checks the password of the account with the given email. Every
+// attempt runs one password verification, against a dummy hash when the email
+// is unknown or the account is locked, so timing doesn't reveal either.
+func (cfg *apiConfig) login(w http.ResponseWriter, r *http.Request) {
+	var request loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+		return
+	}
+
+	guard := cfg.loginGuard
+	now := time.Now().UTC()
+	ipKey := clientIP(r)
+
+	if wait := guard.ips.retryAfter(ipKey, now); wait > 0 {
+		respondWithTooManyAttempts(w, wait)
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUserByEmail(request.Email)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		fmt.Printf("Error loading user: %v\n", err)
+	}
+	if err != nil {
+		user = nil
+	}
+
+	emailKey := models.NormalizeEmail(request.Email)
+	wait := guard.emails.retryAfter(emailKey, now)
+
+	hash := guard.dummyHash
+	if user != nil && wait == 0 {
+		hash = user.Password
+	}
+
+	equalPass, err := password.Verify(request.Password, hash)
+	if err != nil {
+		fmt.Printf("Error verifying password: %v\n", err)
+	}
+
+	if wait > 0 {
+		guard.ips.fail(ipKey, now)
+		respondWithTooManyAttempts(w, wait)
+		return
+	}
+
+	if user == nil || !equalPass {
+		guard.ips.fail(ipKey, now)
+		guard.emails.fail(emailKey, now)
+
+		respondWithError(w, http.StatusUnauthorized, "Wrong username or password")
+		return
+	}
+
+	// The IP counter is left alone: one working account mustn't buy more
+	// guesses against the others.
+	guard.emails.reset(emailKey)
+
+	cfg.rehashPassword(db, user, request.Password)
+
+	if user.Suspended {
+		respondWithError(w, http.StatusForbidden, "This account is suspended")
+		return
+	}
+
+	scope, err := grantScopes(user, request.Scope)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		cfg.startLoginChallenge(w, db, user, request.Device, scope, request.Cookie)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, db, user, request.Device, scope, request.Cookie)
+}
+
+// respondWithLogin finishes a login once every factor has been checked by
+// opening a session for the device. Logging in to an account that is waiting
+// for deletion cancels the deletion. In cookie mode the tokens are set as
+// cookies instead of being returned.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, db *database.DB, user *models.User, device string, scope string, cookie bool) {
+	if user.Deleted() {
+		restoredUser, err := db.RestoreUser(user.Id)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Wrong username or password")
+			return
+		}
+		user = restoredUser
+	}
+
+	claims := &accessClaims{Scope: scope}
+
+	csrfToken := ""
+	if cookie {
+		token, err := newSecretToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error creating CSRF token")
+			return
+		}
+		csrfToken = token
+		claims.CSRFHash = hashToken(csrfToken)
+	}
+
+	tokenString, err := cfg.issueAccessToken(user, claims)
+	if err != nil {
+		fmt.Println("Error signing token:", err)
+		respondWithError(w, http.StatusInternalServerError, "Error signing token")
+		return
+	}
+
+	refreshToken, err := cfg.startSession(r, db, user.Id, device, scope)
+	if err != nil {
+		fmt.Printf("Error starting session: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting session")
+		return
+	}
+
+	if cookie {
+		setSessionCookies(w, tokenString, claims.ExpiresAt.Time, refreshToken, csrfToken)
+		respondWithJSON(w, http.StatusOK, models.APIUserResponse{
+			Id:          user.Id,
+			Email:       user.Email,
+			IsChirpyRed: user.IsChirpyRed,
+			Role:        user.Role,
+			Scope:       scope,
+			CSRFToken:   csrfToken,
+		})
+		return
+	}
+
+	userResponse := models.APIUserResponse{
+		Id:           user.Id,
+		Email:        user.Email,
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		IsChirpyRed:  user.IsChirpyRed,
+		Role:         user.Role,
+		Scope:        scope,
+	}
+
+	respondWithJSON(w, http.StatusOK, userResponse)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// backoff locks out after threshold failures, for base at first and twice
+// as long with each further failure, up to max.
+type backoff struct {
+	threshold int
+	base      time.Duration
+	max       time.Duration
+}
+
+func (b backoff) lockout(failures int) time.Duration {
+	if failures < b.threshold {
+		return 0
+	}
+
+	duration := b.base
+	for i := b.threshold; i < failures && duration < b.max; i++ {
+		duration *= 2
+	}
+
+	return min(duration, b.max)
+}
+
+type throttleEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// loginThrottle counts failures in memory, keyed by client IP or by email.
+type loginThrottle struct {
+	backoff backoff
+	window  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*throttleEntry
+}
+
+func newLoginThrottle(b backoff, window time.Duration) *loginThrottle {
+	return &loginThrottle{
+		backoff: b,
+		window:  window,
+		entries: make(map[string]*throttleEntry),
+	}
+}
+
+func (t *loginThrottle) retryAfter(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.entries[key]
+	if !ok || !now.Before(entry.lockedUntil) {
+		return 0
+	}
+
+	return entry.lockedUntil.Sub(now)
+}
+
+func (t *loginThrottle) fail(key string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.entries) > 10000 {
+		t.prune(now)
+	}
+
+	entry, ok := t.entries[key]
+	if !ok || t.stale(entry, now) {
+		entry = &throttleEntry{}
+		t.entries[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+	if duration := t.backoff.lockout(entry.failures); duration > 0 {
+		entry.lockedUntil = now.Add(duration)
+	}
+}
+
+func (t *loginThrottle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, key)
+}
+
+func (t *loginThrottle) stale(entry *throttleEntry, now time.Time) bool {
+	return now.Sub(entry.lastFailure) > t.window && !now.Before(entry.lockedUntil)
+}
+
+func (t *loginThrottle) prune(now time.Time) {
+	for key, entry := range t.entries {
+		if t.stale(entry, now) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// loginGuard holds the brute-force limits for POST /api/login. Emails and IPs
+// are throttled in memory, and an email is tracked the same way whether or
+// not anyone registered it, so neither the lockout nor the time a failure
+// takes tells who is registered. Lockouts end with a restart.
+type loginGuard struct {
+	ips    *loginThrottle
+	emails *loginThrottle
+
+	// dummyHash is verified against when the email is unknown, so the
+	// response takes as long as for a real account.
+	dummyHash string
+}
+
+func newLoginGuard(dummyHash string) *loginGuard {
+	accounts := backoff{threshold: 5, base: time.Minute, max: time.Hour}
+	ips := backoff{threshold: 20, base: time.Minute, max: time.Hour}
+	window := time.Hour
+
+	return &loginGuard{
+		ips:       newLoginThrottle(ips, window),
+		emails:    newLoginThrottle(accounts, window),
+		dummyHash: dummyHash,
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func respondWithTooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(retryAfter.Round(time.Second).Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later")
+}
@@ -0,0 +1,91 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	data := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		m.From, msg.To, msg.Subject, msg.Body)
+
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, []byte(data))
+}
+
+// LogMailer writes messages to a writer instead of sending them, for local
+// development.
+type LogMailer struct {
+	mux sync.Mutex
+	out io.Writer
+}
+
+func NewLogMailer(out io.Writer) *LogMailer {
+	return &LogMailer{out: out}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	_, err := fmt.Fprintf(m.out, "--- %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+// MemoryMailer keeps sent messages in memory so tests can read them back.
+type MemoryMailer struct {
+	mux      sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *MemoryMailer) Messages() []Message {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// Last returns the most recent message sent to the address.
+func (m *MemoryMailer) Last(to string) (Message, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+
+	return Message{}, false
+}
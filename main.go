@@ -2,15 +2,17 @@ package main
 
 import (
 	"Chirpy/database"
+	"Chirpy/keyring"
+	"Chirpy/media"
 	"Chirpy/models"
-	"crypto/subtle"
-	"encoding/hex"
+	"Chirpy/spam"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
-	"golang.org/x/crypto/bcrypt"
 	"io"
 	"net/http"
 	"os"
@@ -24,10 +26,12 @@ func main() {
 	if err != nil {
 		fmt.Println("Error loading .env file")
 	}
-	jwtSecret := []byte(os.Getenv("JWT_SECRET"))
 	polkaAPI := []byte(os.Getenv("POLKA_API"))
 
 	debug := flag.Bool("debug", false, "Run server in debug mode")
+	newSigningKey := flag.Bool("new-signing-key", false, "Generate a new signing key in JWT_KEYS_DIR, without activating it, and exit")
+	activateSigningKey := flag.String("activate-signing-key", "", "Make the signing key with this kid the active one and exit")
+	bootstrapAdmin := flag.String("bootstrap-admin", "", "Make the registered user with this email the first admin and exit")
 	flag.Parse()
 
 	if *debug {
@@ -39,6 +43,37 @@ func main() {
 		}
 	}
 
+	if *bootstrapAdmin != "" {
+		db, err := database.NewDB("database.json")
+		if err != nil {
+			fmt.Printf("Error opening database: %v\n", err)
+			os.Exit(1)
+		}
+
+		user, err := db.BootstrapAdmin(*bootstrapAdmin)
+		switch {
+		case errors.Is(err, database.ErrConflict):
+			fmt.Println("There is an admin already, appoint further admins with PUT /admin/users/{userID}/role")
+			os.Exit(1)
+		case errors.Is(err, database.ErrNotFound):
+			fmt.Printf("No user with email %s, register the account first\n", *bootstrapAdmin)
+			os.Exit(1)
+		case err != nil:
+			fmt.Printf("Error creating admin: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("User %d (%s) is now an admin\n", user.Id, user.Email)
+		return
+	}
+
+	sweeperDB, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	} else {
+		go sweeperDB.RunExpirySweeper(context.Background(), time.Minute)
+	}
+
 	mux := http.NewServeMux()
 
 	server := &http.Server{
@@ -46,10 +81,144 @@ func main() {
 		Handler: mux,
 	}
 
+	spamRulesPath := os.Getenv("SPAM_RULES")
+	if spamRulesPath == "" {
+		spamRulesPath = "spam_rules.json"
+	}
+
+	spamEngine, err := spam.NewEngine(spamRulesPath)
+	if err != nil {
+		fmt.Printf("Error loading spam rules: %v\n", err)
+		os.Exit(1)
+	}
+	go reloadSpamRulesOnSignal(spamEngine)
+
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		keysDir = "keys"
+	}
+
+	if *newSigningKey {
+		kid, err := keyring.Generate(keysDir, "")
+		if err != nil {
+			fmt.Printf("Error generating signing key: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Generated signing key %s, send SIGHUP to running servers to publish it and activate it after %v\n", kid, jwksMaxAge)
+		return
+	}
+
+	if *activateSigningKey != "" {
+		err := keyring.Activate(keysDir, *activateSigningKey, jwksMaxAge)
+		if err != nil {
+			fmt.Printf("Error activating signing key: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Activated signing key %s, send SIGHUP to running servers to start signing with it\n", *activateSigningKey)
+		return
+	}
+
+	signingKeys, err := keyring.Load(keysDir)
+	if err != nil {
+		fmt.Printf("Error loading signing keys: %v\n", err)
+		os.Exit(1)
+	}
+	go reloadKeysOnSignal(signingKeys)
+
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "uploads"
+	}
+
+	mediaStore, err := media.NewStore(mediaDir)
+	if err != nil {
+		fmt.Printf("Error opening media storage: %v\n", err)
+		os.Exit(1)
+	}
+
+	mailSender, err := newMailerFromEnv()
+	if err != nil {
+		fmt.Printf("Error configuring mailer: %v\n", err)
+		os.Exit(1)
+	}
+
+	baseURL := strings.TrimSuffix(os.Getenv("BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	passwordPolicy, err := passwordPolicyFromEnv()
+	if err != nil {
+		fmt.Printf("Error configuring password policy: %v\n", err)
+		os.Exit(1)
+	}
+
+	passwordHasher, err := passwordHasherFromEnv()
+	if err != nil {
+		fmt.Printf("Error configuring password hasher: %v\n", err)
+		os.Exit(1)
+	}
+
+	dummyHash, err := passwordHasher.Hash("not-a-real-password")
+	if err != nil {
+		fmt.Printf("Error configuring password hasher: %v\n", err)
+		os.Exit(1)
+	}
+
+	oidcProviders, err := oidcProvidersFromEnv(baseURL)
+	if err != nil {
+		fmt.Printf("Error configuring OIDC providers: %v\n", err)
+		os.Exit(1)
+	}
+
+	tokens, err := tokenConfigFromEnv(baseURL)
+	if err != nil {
+		fmt.Printf("Error configuring access tokens: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Token times in milliseconds, so revoking a user's tokens also catches
+	// the ones issued earlier within the same second.
+	jwt.TimePrecision = time.Millisecond
+
+	denylistDB, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+
+	denylist, err := loadTokenDenylist(denylistDB)
+	if err != nil {
+		fmt.Printf("Error loading revoked tokens: %v\n", err)
+		os.Exit(1)
+	}
+
 	cfg := apiConfig{
 		fileserverHits: 0,
-		jwtSecret:      jwtSecret,
+		keyring:        signingKeys,
+		spam:           spamEngine,
+		media:          mediaStore,
+
+		accountDeletionGrace: accountDeletionGraceFromEnv(),
+		exportDir:            os.Getenv("EXPORT_DIR"),
+		mailer:               mailSender,
+		baseURL:              baseURL,
+		passwordPolicy:       passwordPolicy,
+		passwordHasher:       passwordHasher,
+		loginGuard:           newLoginGuard(dummyHash),
+		oidcProviders:        oidcProviders,
+		oidcLogins:           newOIDCLogins(),
+		denylist:             denylist,
+		tokens:               tokens,
 	}
+	if cfg.exportDir == "" {
+		cfg.exportDir = "exports"
+	}
+	go cfg.runAccountPurger(context.Background(), time.Hour)
+	go cfg.runExportSweeper(context.Background(), time.Minute)
+	cfg.resumeExports()
 
 	mux.HandleFunc("GET /api/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -63,8 +232,8 @@ func main() {
 
 		fmt.Printf("Response written to: %d bytes\n", write)
 	})
-	mux.HandleFunc("GET /admin/metrics", cfg.checkMainPageVisit)
-	mux.HandleFunc("GET /api/reset", cfg.resetVisitCounter)
+	mux.HandleFunc("GET /admin/metrics", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleAdmin, cfg.checkMainPageVisit)))
+	mux.HandleFunc("GET /api/reset", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleAdmin, cfg.resetVisitCounter)))
 	mux.HandleFunc("GET /api/chirps", func(w http.ResponseWriter, r *http.Request) {
 		queryAuthorParam := r.URL.Query().Get("author_id")
 		authorId, err := strconv.Atoi(queryAuthorParam)
@@ -84,6 +253,8 @@ func main() {
 			return
 		}
 
+		chirps = cfg.newChirpFilter(r, db).apply(chirps)
+
 		if queryAuthorParam != "" {
 			allChirpsOfAuthor := []models.Storable{}
 
@@ -104,8 +275,8 @@ func main() {
 			respondWithJSON(w, http.StatusOK, sortedChirps)
 		}
 	})
-	mux.HandleFunc("POST /api/chirps", cfg.checkJWTToken(func(w http.ResponseWriter, r *http.Request) {
-		claims := r.Context().Value("claims").(*jwt.RegisteredClaims)
+	mux.HandleFunc("POST /api/chirps", cfg.requireScope(scopeChirpsWrite, cfg.requireVerifiedEmail(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := claimsFromRequest(r)
 		db, err := database.NewDB("database.json")
 		if err != nil {
 			fmt.Printf("Error opening database: %v\n", err)
@@ -134,11 +305,31 @@ func main() {
 			http.Error(w, "Error extracting subject claims", http.StatusInternalServerError)
 		}
 
+		author, err := db.GetUser(authorID)
+		if err != nil || author.Suspended || author.Deleted() {
+			respondWithError(w, http.StatusForbidden, "Your account can't post chirps")
+			return
+		}
+
 		chirp, err := db.CreateChirp(string(bodyBytes), authorID)
 		if err != nil {
-			fmt.Printf("Error creating chirp: %v\n", err)
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
 		}
 
+		typedChirp := chirp.(*models.Chirp)
+		if handle, blocked := blockedMention(db, authorID, typedChirp.Body); blocked {
+			respondWithError(w, http.StatusForbidden, "You can't mention @"+handle)
+			return
+		}
+
+		verdict := cfg.checkSpam(db, author, typedChirp)
+		if verdict.Action == spam.ActionReject {
+			respondWithError(w, http.StatusUnprocessableEntity, "Chirp rejected: "+strings.Join(verdict.Reasons, "; "))
+			return
+		}
+		typedChirp.Held = verdict.Action == spam.ActionHold
+
 		loadDB, err := db.LoadDB()
 		if err != nil {
 			fmt.Printf("Error loading DB: %v\n", err)
@@ -149,84 +340,85 @@ func main() {
 			fmt.Printf("Error writing database: %v\n", err)
 		}
 
+		if typedChirp.Held {
+			_, err = db.CreateReport(typedChirp.Id, 0, "Held by spam filter: "+strings.Join(verdict.Reasons, "; "))
+			if err != nil {
+				fmt.Printf("Error queueing held chirp: %v\n", err)
+			}
+
+			respondWithJSON(w, http.StatusAccepted, chirp)
+			return
+		}
+
 		respondWithJSON(w, http.StatusCreated, chirp)
-	}))
+	})))
 	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
 		db, err := database.NewDB("database.json")
 		if err != nil {
 			fmt.Printf("Error opening database: %v\n", err)
 		}
 
-		path := r.URL.Path
-		sliceOfPath := strings.Split(path, "/")
-		idOfChirp, err := strconv.Atoi(sliceOfPath[len(sliceOfPath)-1])
+		idOfChirp, err := strconv.Atoi(r.PathValue("chirpID"))
 		if err != nil {
-			fmt.Printf("Error converting chirp ID to int: %v\n", err)
-		}
-
-		chirps, err := db.GetItems("chirp")
-		if err != nil {
-			fmt.Printf("Error getting chirp %v\n", chirps)
+			respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+			return
 		}
 
-		if len(chirps) < idOfChirp {
+		chirp, err := db.GetChirp(idOfChirp)
+		if err != nil || !cfg.newChirpFilter(r, db).visible(chirp) {
 			respondWithError(w, http.StatusNotFound, "chirp not found")
-		} else {
-			chirp := chirps[idOfChirp-1]
-			respondWithJSON(w, http.StatusOK, chirp)
+			return
 		}
-	})
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.checkJWTToken(func(w http.ResponseWriter, r *http.Request) {
-		claims := r.Context().Value("claims").(*jwt.RegisteredClaims)
 
+		respondWithJSON(w, http.StatusOK, chirp)
+	})
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.requireScope(scopeChirpsDelete, func(w http.ResponseWriter, r *http.Request) {
 		db, err := database.NewDB("database.json")
 		if err != nil {
 			fmt.Printf("Error opening database: %v\n", err)
 		}
 
-		path := r.URL.Path
-		sliceOfPath := strings.Split(path, "/")
-		idOfChirp, err := strconv.Atoi(sliceOfPath[len(sliceOfPath)-1])
-		if err != nil {
-			fmt.Printf("Error converting chirp ID to int: %v\n", err)
-		}
-
-		chirps, err := db.GetItems("chirp")
-		if err != nil {
-			fmt.Printf("Error getting chirp %v\n", chirps)
-		}
-
-		authorIDStr, err := claims.GetSubject()
+		idOfChirp, err := strconv.Atoi(r.PathValue("chirpID"))
 		if err != nil {
-			http.Error(w, "Error extracting subject claims", http.StatusInternalServerError)
+			respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+			return
 		}
 
-		authorID, err := strconv.Atoi(authorIDStr)
+		authorID, err := userIDFromRequest(r)
 		if err != nil {
 			http.Error(w, "Error extracting subject claims", http.StatusInternalServerError)
+			return
 		}
 
-		if len(chirps) < idOfChirp {
+		chirp, err := db.GetChirp(idOfChirp)
+		if err != nil || chirp.Expired(time.Now()) {
 			respondWithError(w, http.StatusNotFound, "chirp not found")
-		} else {
-			chirp := chirps[idOfChirp-1]
-			typedChirp := chirp.(*models.Chirp)
-
-			if typedChirp.AuthorId == authorID {
-				newDB := db.DeleteItem(idOfChirp, "chirps")
-				var emptyItem models.Storable
-				err = db.WriteDB(newDB, emptyItem)
-				if err != nil {
-					fmt.Printf("Error writing database: %v\n", err)
-				}
+			return
+		}
 
-				respondWithJSON(w, http.StatusNoContent, chirp)
-			} else {
-				respondWithError(w, http.StatusForbidden, "You don't have access to deleting this chirp")
-			}
+		if chirp.AuthorId != authorID {
+			respondWithError(w, http.StatusForbidden, "You don't have access to deleting this chirp")
+			return
+		}
 
+		err = db.DeleteChirp(idOfChirp)
+		if err != nil {
+			fmt.Printf("Error writing database: %v\n", err)
 		}
+
+		w.WriteHeader(http.StatusNoContent)
 	}))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/report", cfg.requireScope(scopeChirpsWrite, cfg.requireVerifiedEmail(cfg.reportChirp)))
+	mux.HandleFunc("GET /admin/reports", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleModerator, cfg.listReports)))
+	mux.HandleFunc("POST /admin/reports/{reportID}/claim", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleModerator, cfg.claimReport)))
+	mux.HandleFunc("POST /admin/reports/{reportID}/resolve", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleModerator, cfg.resolveReport(models.ModerationResolve))))
+	mux.HandleFunc("POST /admin/reports/{reportID}/hide", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleModerator, cfg.resolveReport(models.ModerationHide))))
+	mux.HandleFunc("POST /admin/reports/{reportID}/delete", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleModerator, cfg.resolveReport(models.ModerationDelete))))
+	mux.HandleFunc("POST /admin/users/{userID}/suspend", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleModerator, cfg.suspendUser(true))))
+	mux.HandleFunc("POST /admin/users/{userID}/unsuspend", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleModerator, cfg.suspendUser(false))))
+	mux.HandleFunc("POST /admin/users/{userID}/unlock", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleAdmin, cfg.unlockUser)))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleAdmin, cfg.setUserRole)))
+	mux.HandleFunc("GET /admin/audit", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleModerator, cfg.listAuditLog)))
 	mux.HandleFunc("POST /api/users", func(w http.ResponseWriter, r *http.Request) {
 		db, err := database.NewDB("database.json")
 
@@ -243,240 +435,225 @@ func main() {
 			}
 		}(r.Body)
 
+		var registration models.UserUpdate
+		if err := json.Unmarshal(bodyBytes, &registration); err != nil || registration.Password == nil {
+			respondWithError(w, http.StatusBadRequest, "email and password are required")
+			return
+		}
+
+		if err := cfg.passwordPolicy.Check(*registration.Password, stringValue(registration.Email), stringValue(registration.Handle)); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
 		loadDB, err := db.LoadDB()
 		if err != nil {
 			fmt.Printf("Error loading DB: %v\n", err)
 		}
 
-		user, userResponse, err := db.CreateUser(string(bodyBytes))
+		user, userResponse, err := db.CreateUser(string(bodyBytes), cfg.passwordHasher)
 		if err != nil {
-			fmt.Printf("Error creating chirp: %v\n", err)
+			respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+			return
 		}
 
 		userA, _ := user.(*models.User)
 
-		if !db.EmailValidator(userA.Email) {
-			respondWithError(w, http.StatusConflict, "This email address already exists")
+		if err := models.ValidateEmail(userA.Email); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		err = db.WriteDB(loadDB, user)
-		if err != nil {
-			fmt.Printf("Error writing database: %v\n", err)
+		if !db.EmailValidator(userA.Email) {
+			respondWithError(w, http.StatusConflict, "This email address already exists")
+			return
 		}
 
-		respondWithJSON(w, http.StatusCreated, userResponse)
-	})
-	mux.HandleFunc("PUT /api/users", cfg.checkJWTToken(func(w http.ResponseWriter, r *http.Request) {
-		claims := r.Context().Value("claims").(*jwt.RegisteredClaims)
-		db, err := database.NewDB("database.json")
-		if err != nil {
-			fmt.Printf("Error opening database: %v\n", err)
+		profile := models.UserUpdate{DisplayName: &userA.DisplayName, Bio: &userA.Bio}
+		if userA.Handle != "" {
+			profile.Handle = &userA.Handle
 		}
-
-		bodyBytes, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		if status, err := validateProfileFields(db, 0, profile); err != nil {
+			respondWithError(w, status, err.Error())
 			return
 		}
 
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
-			if err != nil {
-				fmt.Printf("Error writing response: %v\n", err)
-			}
-		}(r.Body)
-
-		userID, err := strconv.Atoi(claims.Subject)
+		err = db.WriteDB(loadDB, user)
 		if err != nil {
-			fmt.Printf("Error converting user ID to int: %v\n", err)
+			fmt.Printf("Error writing database: %v\n", err)
 		}
 
-		newDB := db.DeleteItem(userID, "users")
-		updatedUser, updatedUserResponse, err := db.UpdateItem(string(bodyBytes), "user", userID)
-
-		err = db.WriteDB(newDB, updatedUser)
+		err = cfg.sendVerificationEmail(db, userA)
 		if err != nil {
-			fmt.Printf("Error writing database: %v\n", err)
+			fmt.Printf("Error sending verification email: %v\n", err)
 		}
 
-		respondWithJSON(w, http.StatusOK, updatedUserResponse)
-	}))
-	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
-		checkFlag := false
+		respondWithJSON(w, http.StatusCreated, userResponse)
+	})
+	mux.HandleFunc("PUT /api/users", cfg.requireScope(scopeProfileWrite, func(w http.ResponseWriter, r *http.Request) {
 		db, err := database.NewDB("database.json")
 		if err != nil {
 			fmt.Printf("Error opening database: %v\n", err)
 		}
 
-		bodyBytes, err := io.ReadAll(r.Body)
+		userID, err := userIDFromRequest(r)
 		if err != nil {
-			http.Error(w, "Error reading request body", http.StatusInternalServerError)
+			respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
 			return
 		}
 
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
-			if err != nil {
-				fmt.Printf("Error writing response: %v\n", err)
-			}
-		}(r.Body)
-
-		unmarshalFunc, ok := models.UnmarshalFunc["user"]
-		if !ok {
-			fmt.Printf("Error unmarshalling user: %v\n", err)
-		}
-
-		item, err := unmarshalFunc(bodyBytes)
-		if err != nil {
-			fmt.Printf("Error unmarshalling user: %v\n", err)
+		var update models.UserUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+			return
 		}
 
-		users, err := db.GetItems("user")
-		if err != nil {
-			respondWithError(w, http.StatusNotFound, "users not found")
+		// Email and password are credentials, not profile fields, and the
+		// token lifetime is a security setting.
+		claims, _ := claimsFromRequest(r)
+		if (update.Email != nil || update.Password != nil || update.ExpiresInSeconds != nil) && !claims.hasScope(scopeAccount) {
+			respondWithInsufficientScope(w, scopeAccount)
+			return
 		}
 
-		userA, _ := item.(*models.User)
-
-		for _, user := range users {
-			userB, _ := user.(*models.User)
-
-			equalPass := bcrypt.CompareHashAndPassword([]byte(userB.Password), []byte(userA.Password))
-			if userA.Email == userB.Email && equalPass == nil {
-				checkFlag = true
-
-				claims := &jwt.RegisteredClaims{
-					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 1)),
-					Issuer:    "chirpy",
-					Subject:   strconv.Itoa(userB.Id),
-				}
-
-				token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-				tokenString, err := token.SignedString(jwtSecret)
-				if err != nil {
-					fmt.Println("Error signing token:", err)
-					return
-				}
-
-				userResponse := models.APIUserResponse{
-					Id:           userB.Id,
-					Email:        userB.Email,
-					Token:        tokenString,
-					RefreshToken: userB.RefreshToken,
-					IsChirpyRed:  userB.IsChirpyRed,
-				}
-
-				respondWithJSON(w, http.StatusOK, userResponse)
+		if update.ExpiresInSeconds != nil {
+			if err := cfg.tokens.checkUserTTL(*update.ExpiresInSeconds); err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
 			}
 		}
 
-		if !checkFlag {
-			respondWithError(w, http.StatusUnauthorized, "Wrong username or password")
-		}
-	})
-	mux.HandleFunc("POST /api/refresh", func(w http.ResponseWriter, r *http.Request) {
-		db, err := database.NewDB("database.json")
-		if err != nil {
-			fmt.Printf("Error opening database: %v\n", err)
-		}
-
-		equal := false
-		userId := 0
-
-		headerAuth := r.Header.Get("Authorization")
-		refreshTokenWithoutPrefix := strings.TrimPrefix(headerAuth, "Bearer ")
-
-		users, err := db.GetItems("user")
-		if err != nil {
-			respondWithError(w, http.StatusNotFound, "users not found")
+		if status, err := validateProfileFields(db, userID, update); err != nil {
+			respondWithError(w, status, err.Error())
+			return
 		}
 
-		bytes1, err := hex.DecodeString(refreshTokenWithoutPrefix)
+		currentUser, err := db.GetUser(userID)
 		if err != nil {
-			fmt.Println(err)
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return
 		}
 
-		for _, user := range users {
-			userB, _ := user.(*models.User)
-
-			bytes2, err := hex.DecodeString(userB.RefreshToken)
+		if update.Password != nil {
+			err := cfg.passwordPolicy.Check(*update.Password, currentUser.Email, currentUser.Handle)
 			if err != nil {
-				fmt.Println(err)
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
 			}
+		}
 
-			if subtle.ConstantTimeCompare(bytes1, bytes2) == 1 {
-				equal = true
-				userId = userB.Id
-			}
+		if update.Email != nil {
+			email := models.NormalizeEmail(*update.Email)
+			update.Email = &email
 		}
 
-		if equal {
-			claims := &jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 1)),
-				Issuer:    "chirpy",
-				Subject:   strconv.Itoa(userId),
+		emailChanged := update.Email != nil && *update.Email != currentUser.Email
+		if emailChanged {
+			if err := models.ValidateEmail(*update.Email); err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
 			}
 
-			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-			tokenString, err := token.SignedString(jwtSecret)
-			if err != nil {
-				fmt.Println("Error signing token:", err)
+			if !db.EmailValidator(*update.Email) {
+				respondWithError(w, http.StatusConflict, "This email address already exists")
 				return
 			}
+		}
 
-			tokenResponse := models.TokenResponse{
-				Token: tokenString,
+		updatedUser, err := db.UpdateUser(userID, func(user *models.User) error {
+			if emailChanged {
+				user.Email = *update.Email
+				user.EmailVerified = false
 			}
-			respondWithJSON(w, http.StatusOK, tokenResponse)
-		} else {
-			respondWithError(w, http.StatusUnauthorized, "Wrong refresh token")
-		}
-	})
-	mux.HandleFunc("POST /api/revoke", func(w http.ResponseWriter, r *http.Request) {
-		db, err := database.NewDB("database.json")
+			if update.Password != nil {
+				err := user.SetPassword(*update.Password, cfg.passwordHasher)
+				if err != nil {
+					return err
+				}
+			}
+			if update.Handle != nil {
+				user.Handle = *update.Handle
+			}
+			if update.DisplayName != nil {
+				user.DisplayName = *update.DisplayName
+			}
+			if update.Bio != nil {
+				user.Bio = *update.Bio
+			}
+			if update.ExpiresInSeconds != nil {
+				user.ExpiresInSeconds = *update.ExpiresInSeconds
+			}
+			return nil
+		})
 		if err != nil {
-			fmt.Printf("Error loading DB: %v\n", err)
+			respondWithError(w, http.StatusInternalServerError, "Error writing database")
+			return
 		}
 
-		allUsers, err := db.GetItems("user")
-		if err != nil {
-			respondWithError(w, http.StatusNotFound, "users not found")
+		if update.Password != nil {
+			err = db.RevokeSessionsOfUser(userID)
+			if err != nil {
+				fmt.Printf("Error revoking sessions of user %d: %v\n", userID, err)
+			}
+			cfg.revokeTokensOfUser(db, userID)
 		}
 
-		headerAuth := r.Header.Get("Authorization")
-		refreshTokenWithoutPrefix := strings.TrimPrefix(headerAuth, "Bearer ")
-		flagCheck := false
-
-		for _, user := range allUsers {
-			userB, _ := user.(*models.User)
-
-			if userB.RefreshToken == refreshTokenWithoutPrefix {
-				flagCheck = true
-				newDB := db.RevokeRefreshToken(userB.Id)
-
-				data, err := json.Marshal(newDB)
-				if err != nil {
-					fmt.Printf("Error marshalling DB: %v\n", err)
-				}
-
-				err = os.WriteFile("database.json", data, 0644)
-				if err != nil {
-					fmt.Printf("Error writing DB: %v\n", err)
-				}
-
-				w.Header().Set("Content-Type", "application/json")
-				w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
-				w.WriteHeader(http.StatusNoContent)
+		if emailChanged {
+			err = cfg.sendVerificationEmail(db, updatedUser)
+			if err != nil {
+				fmt.Printf("Error sending verification email: %v\n", err)
 			}
 		}
 
-		if !flagCheck {
-			respondWithError(w, http.StatusUnauthorized, "There is not yours token")
-		}
-	})
+		respondWithJSON(w, http.StatusOK, updatedUser.Response())
+	}))
+	mux.HandleFunc("GET /api/users/verify", cfg.verifyEmail)
+	mux.HandleFunc("POST /api/users/verify/resend", cfg.requireScope(scopeAccount, cfg.resendVerification))
+	mux.HandleFunc("POST /api/users/2fa/enroll", cfg.requireScope(scopeAccount, cfg.enrollTwoFactor))
+	mux.HandleFunc("POST /api/users/2fa/confirm", cfg.requireScope(scopeAccount, cfg.confirmTwoFactor))
+	mux.HandleFunc("POST /api/users/2fa/disable", cfg.requireScope(scopeAccount, cfg.disableTwoFactor))
+	mux.HandleFunc("DELETE /api/users", cfg.requireScope(scopeAccount, cfg.deleteAccount))
+	mux.HandleFunc("POST /api/users/export", cfg.requireScope(scopeAccount, cfg.startExport))
+	mux.HandleFunc("GET /api/users/export/{exportID}", cfg.requireScope(scopeAccount, cfg.getExportStatus))
+	mux.HandleFunc("GET /api/users/export/{exportID}/download", cfg.downloadExport)
+	mux.HandleFunc("GET /api/users/{userID}", cfg.getProfile)
+	mux.HandleFunc("GET /api/users/handle/{handle}", cfg.getProfileByHandle)
+	mux.HandleFunc("POST /api/users/{userID}/follow", cfg.requireScope(scopeProfileWrite, cfg.requireVerifiedEmail(cfg.setFollow(true))))
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", cfg.requireScope(scopeProfileWrite, cfg.setFollow(false)))
+	mux.HandleFunc("PUT /api/users/avatar", cfg.requireScope(scopeProfileWrite, cfg.requireVerifiedEmail(cfg.uploadAvatar)))
+	mux.HandleFunc("DELETE /api/users/avatar", cfg.requireScope(scopeProfileWrite, cfg.deleteAvatar))
+	mux.HandleFunc("GET /media/{name}", cfg.serveMedia)
+	mux.HandleFunc("GET /api/users/preferences", cfg.checkJWTToken(cfg.getPreferences))
+	mux.HandleFunc("PUT /api/users/preferences", cfg.requireScope(scopeProfileWrite, cfg.updatePreferences))
+	mux.HandleFunc("GET /api/users/blocks", cfg.checkJWTToken(cfg.listBlocked))
+	mux.HandleFunc("POST /api/users/{userID}/block", cfg.requireScope(scopeProfileWrite, cfg.setBlock(true)))
+	mux.HandleFunc("DELETE /api/users/{userID}/block", cfg.requireScope(scopeProfileWrite, cfg.setBlock(false)))
+	mux.HandleFunc("GET /api/users/mutes", cfg.checkJWTToken(cfg.listMuted))
+	mux.HandleFunc("POST /api/users/{userID}/mute", cfg.requireScope(scopeProfileWrite, cfg.setMute(true)))
+	mux.HandleFunc("DELETE /api/users/{userID}/mute", cfg.requireScope(scopeProfileWrite, cfg.setMute(false)))
+	mux.HandleFunc("POST /api/login", cfg.login)
+	mux.HandleFunc("POST /api/login/2fa", cfg.verifyLogin)
+	mux.HandleFunc("GET /api/oidc/{provider}/login", cfg.startOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/{provider}/callback", cfg.finishOIDCLogin)
+	mux.HandleFunc("POST /api/password/forgot", cfg.forgotPassword)
+	mux.HandleFunc("POST /api/password/reset", cfg.resetPassword)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.serveJWKS)
+	mux.HandleFunc("POST /api/refresh", cfg.refresh)
+	mux.HandleFunc("POST /api/revoke", cfg.revoke)
+	mux.HandleFunc("POST /api/logout", cfg.checkJWTToken(cfg.logout))
+	mux.HandleFunc("POST /api/keys", cfg.requireScope(scopeAccount, cfg.createAPIKey))
+	mux.HandleFunc("GET /api/keys", cfg.requireScope(scopeAccount, cfg.listAPIKeys))
+	mux.HandleFunc("DELETE /api/keys/{keyID}", cfg.requireScope(scopeAccount, cfg.revokeAPIKey))
+	mux.HandleFunc("POST /api/oauth/clients", cfg.requireScope(scopeAccount, cfg.registerOAuthClient))
+	mux.HandleFunc("GET /api/oauth/clients", cfg.requireScope(scopeAccount, cfg.listOAuthClients))
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", cfg.requireScope(scopeAccount, cfg.deleteOAuthClient))
+	mux.HandleFunc("GET /oauth/authorize", cfg.requireScope(scopeAccount, cfg.getAuthorization))
+	mux.HandleFunc("POST /oauth/authorize", cfg.requireScope(scopeAccount, cfg.authorize))
+	mux.HandleFunc("POST /oauth/token", cfg.oauthToken)
+	mux.HandleFunc("POST /oauth/revoke", cfg.oauthRevoke)
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", cfg.serveOAuthMetadata)
+	mux.HandleFunc("GET /api/sessions", cfg.requireScope(scopeAccount, cfg.listSessions))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.requireScope(scopeAccount, cfg.revokeSession))
 	mux.HandleFunc("POST /api/polka/webhooks", func(w http.ResponseWriter, r *http.Request) {
 		headerAuth := r.Header.Get("Authorization")
 		polkaAPIKeyWithoutPrefix := strings.TrimPrefix(headerAuth, "ApiKey ")
@@ -513,41 +690,19 @@ func main() {
 				fmt.Printf("Error opening database: %v\n", err)
 			}
 
-			allUsers, err := db.GetItems("user")
-			if err != nil {
+			_, err = db.UpdateUser(data.Data.UserID, func(user *models.User) error {
+				user.IsChirpyRed = true
+				return nil
+			})
+			if errors.Is(err, database.ErrNotFound) {
 				respondWithError(w, http.StatusNotFound, "users not found")
+				return
 			}
-
-			if len(allUsers) < data.Data.UserID {
-				respondWithError(w, http.StatusNotFound, "users not found")
-			} else {
-				ourUser := allUsers[data.Data.UserID-1]
-				ourTypedUser := ourUser.(*models.User)
-
-				user := fmt.Sprintf(`{"email": "%s", 
-					"password": "%s", 
-					"is_chirpy_red": %v,
-					"refresh_token": "%s",
-					"expires_in_seconds": %d
-					}`, ourTypedUser.Email,
-					ourTypedUser.Password,
-					true,
-					ourTypedUser.RefreshToken,
-					ourTypedUser.ExpiresInSeconds)
-
-				updatedUser, _, err := db.UpdateItem(user, "user", ourTypedUser.Id)
-				if err != nil {
-					return
-				}
-
-				newDB := db.DeleteItem(ourTypedUser.Id, "users")
-				err = db.WriteDB(newDB, updatedUser)
-				if err != nil {
-					fmt.Printf("Error writing database: %v\n", err)
-				}
-
-				w.WriteHeader(http.StatusNoContent)
+			if err != nil {
+				fmt.Printf("Error writing database: %v\n", err)
 			}
+
+			w.WriteHeader(http.StatusNoContent)
 		}
 	})
 
@@ -0,0 +1,93 @@
+package media
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrUnsupportedType = errors.New("unsupported media type")
+
+var extensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Store keeps uploaded files in a local directory under random names.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// SaveImage sniffs the content type of data and stores it when it is one of
+// the supported image formats. It returns the generated file name.
+func (s *Store) SaveImage(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+
+	extension, ok := extensions[contentType]
+	if !ok {
+		return "", ErrUnsupportedType
+	}
+
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	name := hex.EncodeToString(randomBytes) + extension
+	err = os.WriteFile(filepath.Join(s.dir, name), data, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+func (s *Store) Path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", os.ErrNotExist
+	}
+
+	return filepath.Join(s.dir, name), nil
+}
+
+func (s *Store) Delete(name string) error {
+	path, err := s.Path(name)
+	if err != nil {
+		return nil
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func URL(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	return "/media/" + name
+}
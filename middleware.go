@@ -1,18 +1,43 @@
 package main
 
 import (
+	"Chirpy/database"
+	"Chirpy/keyring"
+	"Chirpy/mailer"
+	"Chirpy/media"
+	"Chirpy/models"
+	"Chirpy/oidc"
+	"Chirpy/password"
+	"Chirpy/spam"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type apiConfig struct {
 	fileserverHits int
-	jwtSecret      []byte
+	keyring        *keyring.Keyring
+	spam           *spam.Engine
+	media          *media.Store
+
+	accountDeletionGrace time.Duration
+	exportDir            string
+	runningExports       sync.Map
+	mailer               mailer.Mailer
+	baseURL              string
+	passwordPolicy       password.Policy
+	passwordHasher       password.Hasher
+	loginGuard           *loginGuard
+	oidcProviders        map[string]*oidc.Provider
+	oidcLogins           *oidcLogins
+	denylist             *tokenDenylist
+	tokens               tokenConfig
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -57,6 +82,18 @@ func (cfg *apiConfig) resetVisitCounter(w http.ResponseWriter, r *http.Request)
 
 	cfg.fileserverHits = 0
 
+	if adminID, err := userIDFromRequest(r); err == nil {
+		db, err := database.NewDB("database.json")
+		if err != nil {
+			fmt.Printf("Error opening database: %v\n", err)
+		}
+
+		err = db.RecordAudit(adminID, "metrics.reset", "metrics", 0, "")
+		if err != nil {
+			fmt.Printf("Error writing audit log: %v\n", err)
+		}
+	}
+
 	write, err := w.Write([]byte("Hits: " + strconv.Itoa(cfg.fileserverHits) + "\n"))
 	if err != nil {
 		fmt.Printf("Error writing response: %v\n", err)
@@ -65,45 +102,155 @@ func (cfg *apiConfig) resetVisitCounter(w http.ResponseWriter, r *http.Request)
 	fmt.Printf("Response written to: %d bytes\n", write)
 }
 
+// checkJWTToken puts the claims of a valid access token or API key into the
+// request context. Nested checks reuse the claims already there.
 func (cfg *apiConfig) checkJWTToken(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		headerAuth := r.Header.Get("Authorization")
-		tokenWithoutPrefix := strings.TrimPrefix(headerAuth, "Bearer ")
-
-		claims := &jwt.RegisteredClaims{}
-		token, err := jwt.ParseWithClaims(tokenWithoutPrefix, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return cfg.jwtSecret, nil
-		})
+		if _, ok := claimsFromRequest(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
 
+		claims, err := cfg.authenticate(r)
+		if errors.Is(err, errCSRF) {
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
 		if err != nil {
 			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		if !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+		ctx := context.WithValue(r.Context(), "claims", claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// authenticate reads the Authorization header, which holds either
+// "Bearer <access token>" or "ApiKey <key>". Without the header the access
+// token may come from the cookie of cookie mode, which needs the CSRF token
+// on unsafe requests.
+func (cfg *apiConfig) authenticate(r *http.Request) (*accessClaims, error) {
+	headerAuth := r.Header.Get("Authorization")
+
+	if secret, ok := strings.CutPrefix(headerAuth, "ApiKey "); ok {
+		return cfg.parseAPIKey(secret)
+	}
+
+	if cookie, err := r.Cookie(accessCookie); headerAuth == "" && err == nil {
+		claims, err := cfg.parseJWTToken(cookie.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return claims, checkCSRF(r, claims)
+	}
+
+	return cfg.parseJWTToken(strings.TrimPrefix(headerAuth, "Bearer "))
+}
+
+func (cfg *apiConfig) parseJWTToken(tokenString string) (*accessClaims, error) {
+	claims := &accessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, cfg.verificationKey,
+		jwt.WithValidMethods([]string{"EdDSA"}),
+		jwt.WithAudience(cfg.tokens.Audience),
+		jwt.WithIssuedAt(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if claims.ExpiresAt == nil || claims.ExpiresAt.Time.Before(time.Now()) {
+		return nil, errors.New("token has expired")
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("token subject missing")
+	}
+
+	if claims.Issuer != "chirpy" {
+		return nil, errors.New("invalid issuer")
+	}
+
+	if cfg.denylist.revoked(claims) {
+		return nil, errors.New("token has been revoked")
+	}
+
+	return claims, nil
+}
+
+func claimsFromRequest(r *http.Request) (*accessClaims, bool) {
+	claims, ok := r.Context().Value("claims").(*accessClaims)
+	return claims, ok
+}
+
+func userIDFromRequest(r *http.Request) (int, error) {
+	claims, ok := claimsFromRequest(r)
+	if !ok {
+		return 0, errors.New("claims missing from request context")
+	}
+
+	return strconv.Atoi(claims.Subject)
+}
+
+// requireRole lets through users with role or a role above it. The role
+// claim of the token has to say so, and so does the database, so a demotion
+// takes effect before the token expires.
+func (cfg *apiConfig) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.checkJWTToken(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromRequest(r)
+		if !ok {
+			http.Error(w, "Error extracting subject claims", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			http.Error(w, "Error extracting subject claims", http.StatusUnauthorized)
 			return
 		}
 
-		if claims.ExpiresAt.Time.Before(time.Now()) {
-			http.Error(w, "Token has expired", http.StatusUnauthorized)
+		db, err := database.NewDB("database.json")
+		if err != nil {
+			fmt.Printf("Error opening database: %v\n", err)
+		}
+
+		user, err := db.GetUser(userID)
+		if err != nil || !models.RoleAtLeast(claims.Role, role) || !user.HasRole(role) || user.Suspended {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("The %s role is required", role))
 			return
 		}
 
-		if claims.Subject == "" {
-			http.Error(w, "Token subject missing", http.StatusUnauthorized)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireVerifiedEmail keeps accounts that haven't confirmed their email
+// address away from actions that reach other users.
+func (cfg *apiConfig) requireVerifiedEmail(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.checkJWTToken(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := userIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "Error extracting subject claims", http.StatusUnauthorized)
 			return
 		}
 
-		if claims.Issuer != "chirpy" {
-			http.Error(w, "Invalid issuer", http.StatusUnauthorized)
+		db, err := database.NewDB("database.json")
+		if err != nil {
+			fmt.Printf("Error opening database: %v\n", err)
+		}
+
+		user, err := db.GetUser(userID)
+		if err != nil || !user.EmailVerified {
+			respondWithError(w, http.StatusForbidden, "Please verify your email address first")
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), "claims", claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	}
+		next.ServeHTTP(w, r)
+	})
 }
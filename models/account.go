@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Stages of the hard delete of an account, in the order they run. The current
+// stage is stored on the user so an interrupted purge resumes where it stopped.
+const (
+	PurgeStageMedia     = "media"
+	PurgeStageChirps    = "chirps"
+	PurgeStageRelations = "relations"
+	PurgeStageAccount   = "account"
+)
+
+// ExternalIdentity links the user to an account at an OpenID provider.
+// Subject is the provider's id for that account, which unlike the email never
+// changes.
+type ExternalIdentity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+func (u *User) Deleted() bool {
+	return u.DeletedAt != nil
+}
+
+// NormalizeEmail gives the form emails are stored, compared and looked up in.
+// Addresses differing only in case reach the same mailbox at nearly every
+// provider, so they are one address here.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(email)
+}
+
+func ValidateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return errors.New("email is not a valid address")
+	}
+
+	if !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return errors.New("email is not a valid address")
+	}
+
+	return nil
+}
+
+type PasswordReset struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"user_id"`
+	TokenHash string     `json:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LoginChallenge is handed out by a password login when the account has two
+// factor authentication on. It is exchanged for tokens at /api/login/2fa.
+type LoginChallenge struct {
+	Id        int       `json:"id"`
+	UserId    int       `json:"user_id"`
+	TokenHash string    `json:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Attempts  int       `json:"attempts"`
+	Device    string    `json:"device,omitempty"`
+	Scope     string    `json:"scope,omitempty"`
+	Cookie    bool      `json:"cookie,omitempty"`
+}
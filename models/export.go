@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+const (
+	ExportStatusPending = "pending"
+	ExportStatusReady   = "ready"
+	ExportStatusFailed  = "failed"
+	// ExportStatusExpired means the archive was deleted after ExpiresAt.
+	ExportStatusExpired = "expired"
+)
+
+type DataExport struct {
+	Id                int        `json:"id"`
+	UserId            int        `json:"user_id"`
+	Status            string     `json:"status"`
+	FileName          string     `json:"file_name,omitempty"`
+	DownloadTokenHash string     `json:"download_token_hash,omitempty"`
+	DownloadExpiresAt *time.Time `json:"download_expires_at,omitempty"`
+	Error             string     `json:"error,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	CompletedAt       *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+}
+
+type DataExportResponse struct {
+	Id                int        `json:"id"`
+	Status            string     `json:"status"`
+	StatusURL         string     `json:"status_url"`
+	DownloadURL       string     `json:"download_url,omitempty"`
+	DownloadExpiresAt *time.Time `json:"download_expires_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	CompletedAt       *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+}
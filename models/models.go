@@ -1,11 +1,9 @@
 package models
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"Chirpy/password"
 	"encoding/json"
-	"fmt"
-	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 type Storable interface {
@@ -14,9 +12,15 @@ type Storable interface {
 }
 
 type Chirp struct {
-	Id       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorId int    `json:"author_id"`
+	Id             int        `json:"id"`
+	Body           string     `json:"body"`
+	AuthorId       int        `json:"author_id"`
+	ContentWarning string     `json:"content_warning,omitempty"`
+	Sensitive      bool       `json:"sensitive"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	Hidden         bool       `json:"hidden,omitempty"`
+	Held           bool       `json:"held,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
 }
 
 func (c *Chirp) SetId(id int) {
@@ -27,31 +31,91 @@ func (c *Chirp) GetId() int {
 	return c.Id
 }
 
+func (c *Chirp) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !c.ExpiresAt.After(now)
+}
+
 type User struct {
-	Id               int    `json:"id"`
-	Email            string `json:"email"`
-	Password         string `json:"password"`
-	ExpiresInSeconds int    `json:"expires_in_seconds"`
-	RefreshToken     string `json:"refresh_token"`
-	IsChirpyRed      bool   `json:"is_chirpy_red"`
+	Id               int         `json:"id"`
+	Email            string      `json:"email"`
+	Password         string      `json:"password"`
+	ExpiresInSeconds int         `json:"expires_in_seconds"`
+	IsChirpyRed      bool        `json:"is_chirpy_red"`
+	Preferences      Preferences `json:"preferences"`
+	Role             string      `json:"role"`
+	Suspended        bool        `json:"suspended"`
+	CreatedAt        time.Time   `json:"created_at"`
+	Handle           string      `json:"handle"`
+	DisplayName      string      `json:"display_name"`
+	Bio              string      `json:"bio"`
+	AvatarFile       string      `json:"avatar_file"`
+	DeletedAt        *time.Time  `json:"deleted_at,omitempty"`
+	PurgeStage       string      `json:"purge_stage,omitempty"`
+
+	EmailVerified         bool       `json:"email_verified"`
+	VerificationTokenHash string     `json:"verification_token_hash,omitempty"`
+	VerificationExpiresAt *time.Time `json:"verification_expires_at,omitempty"`
+
+	TwoFactorEnabled   bool     `json:"two_factor_enabled"`
+	TOTPSecret         string   `json:"totp_secret,omitempty"`
+	PendingTOTPSecret  string   `json:"pending_totp_secret,omitempty"`
+	TOTPLastCounter    int64    `json:"totp_last_counter,omitempty"`
+	RecoveryCodeHashes []string `json:"recovery_code_hashes,omitempty"`
+
+	ExternalIdentities []ExternalIdentity `json:"external_identities,omitempty"`
+
+	// TokensRevokedBefore invalidates the access tokens issued before it.
+	TokensRevokedBefore *time.Time `json:"tokens_revoked_before,omitempty"`
+
+	// LegacyModerator is the flag roles replaced; it is only read to
+	// migrate old databases.
+	LegacyModerator bool `json:"is_moderator,omitempty"`
+}
+
+const (
+	SensitiveContentWarn   = "warn"
+	SensitiveContentExpand = "expand"
+	SensitiveContentHide   = "hide"
+)
+
+type Preferences struct {
+	SensitiveContent string `json:"sensitive_content"`
+}
+
+func (p Preferences) HidesSensitive() bool {
+	return p.SensitiveContent == SensitiveContentHide
 }
 
 type UserResponse struct {
-	Id          int    `json:"id"`
-	Email       string `json:"email"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id            int    `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	TwoFactor     bool   `json:"two_factor_enabled"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	Handle        string `json:"handle,omitempty"`
+	DisplayName   string `json:"display_name,omitempty"`
+	Bio           string `json:"bio,omitempty"`
+
+	ExpiresInSeconds int `json:"expires_in_seconds,omitempty"`
 }
 
 type APIUserResponse struct {
 	Id           int    `json:"id"`
 	Email        string `json:"email"`
-	Token        string `json:"token"`
-	RefreshToken string `json:"refresh_token"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	Role         string `json:"role"`
+	Scope        string `json:"scope"`
+	// CSRFToken is only set in cookie mode, where the tokens are cookies.
+	CSRFToken string `json:"csrf_token,omitempty"`
 }
 
 type TokenResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+	CSRFToken    string `json:"csrf_token,omitempty"`
 }
 
 func (u *User) SetId(id int) {
@@ -62,27 +126,16 @@ func (u *User) GetId() int {
 	return u.Id
 }
 
-func (u *User) SetHashPass(pass string) {
-	if len(pass) == 60 {
-		u.Password = pass
-	} else {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
-		if err != nil {
-			fmt.Println(err)
-		}
-		u.Password = string(hashedPassword)
-	}
-}
-
-func (u *User) GenerateRefreshToken() {
-	randomBytes := make([]byte, 32)
-
-	_, err := rand.Read(randomBytes)
+// SetPassword hashes a new plaintext password. The Password field otherwise
+// only ever holds an encoded hash loaded from storage.
+func (u *User) SetPassword(plain string, hasher password.Hasher) error {
+	hashedPassword, err := hasher.Hash(plain)
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
 
-	u.RefreshToken = hex.EncodeToString(randomBytes)
+	u.Password = hashedPassword
+	return nil
 }
 
 type Webhooks struct {
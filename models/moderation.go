@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+const (
+	ReportStatusOpen     = "open"
+	ReportStatusClaimed  = "claimed"
+	ReportStatusResolved = "resolved"
+)
+
+const (
+	ModerationResolve = "resolve"
+	ModerationHide    = "hide"
+	ModerationDelete  = "delete"
+)
+
+type Report struct {
+	Id         int       `json:"id"`
+	ChirpId    int       `json:"chirp_id"`
+	ReporterId int       `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	Status     string    `json:"status"`
+	ClaimedBy  int       `json:"claimed_by,omitempty"`
+	Action     string    `json:"action,omitempty"`
+	Resolution string    `json:"resolution,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type AuditEntry struct {
+	Id         int       `json:"id"`
+	ActorId    int       `json:"actor_id"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetId   int       `json:"target_id"`
+	Details    string    `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
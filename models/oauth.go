@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// OAuthClient is a third-party app registered by a user. Confidential clients
+// have a secret, stored by hash; public ones, such as mobile apps, don't and
+// rely on PKCE alone.
+type OAuthClient struct {
+	Id           int       `json:"id"`
+	ClientId     string    `json:"client_id"`
+	OwnerId      int       `json:"owner_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type OAuthClientResponse struct {
+	ClientId     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+}
+
+func (c *OAuthClient) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+func (c *OAuthClient) Response() OAuthClientResponse {
+	return OAuthClientResponse{
+		ClientId:     c.ClientId,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectURIs,
+		Confidential: c.Confidential(),
+		CreatedAt:    c.CreatedAt,
+	}
+}
+
+// OAuthCode is an authorization code waiting to be exchanged for tokens.
+// After the exchange it is kept, with the session it opened, until it
+// expires, so a second exchange can revoke that session.
+type OAuthCode struct {
+	Id            int        `json:"id"`
+	CodeHash      string     `json:"code_hash"`
+	ClientId      string     `json:"client_id"`
+	UserId        int        `json:"user_id"`
+	RedirectURI   string     `json:"redirect_uri"`
+	Scope         string     `json:"scope"`
+	CodeChallenge string     `json:"code_challenge"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at,omitempty"`
+	SessionId     int        `json:"session_id,omitempty"`
+}
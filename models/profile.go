@@ -0,0 +1,76 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+)
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,15}$`)
+
+// UserUpdate carries a partial update of a user; nil fields are left as they are.
+type UserUpdate struct {
+	Email       *string `json:"email"`
+	Password    *string `json:"password"`
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	// ExpiresInSeconds is the lifetime of the user's access tokens, 0 for
+	// the server's default.
+	ExpiresInSeconds *int `json:"expires_in_seconds"`
+}
+
+type ProfileResponse struct {
+	Id             int    `json:"id"`
+	Handle         string `json:"handle"`
+	DisplayName    string `json:"display_name"`
+	Bio            string `json:"bio"`
+	AvatarURL      string `json:"avatar_url"`
+	FollowerCount  int    `json:"follower_count"`
+	FollowingCount int    `json:"following_count"`
+	ChirpCount     int    `json:"chirp_count"`
+}
+
+func ValidateHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return errors.New("handle must be 3 to 15 letters, digits or underscores")
+	}
+
+	return nil
+}
+
+func ValidateDisplayName(displayName string) error {
+	if utf8.RuneCountInString(displayName) > MaxDisplayNameLength {
+		return errors.New("display_name must be at most 50 characters")
+	}
+
+	return nil
+}
+
+func ValidateBio(bio string) error {
+	if utf8.RuneCountInString(bio) > MaxBioLength {
+		return errors.New("bio must be at most 160 characters")
+	}
+
+	return nil
+}
+
+func (u *User) Response() UserResponse {
+	return UserResponse{
+		Id:            u.Id,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		TwoFactor:     u.TwoFactorEnabled,
+		IsChirpyRed:   u.IsChirpyRed,
+		Handle:        u.Handle,
+		DisplayName:   u.DisplayName,
+		Bio:           u.Bio,
+
+		ExpiresInSeconds: u.ExpiresInSeconds,
+	}
+}
@@ -0,0 +1,28 @@
+package models
+
+// Roles, each allowed everything the ones before it are.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role is required or a role above it.
+func RoleAtLeast(role string, required string) bool {
+	return ValidRole(required) && roleRanks[role] >= roleRanks[required]
+}
+
+func (u *User) HasRole(required string) bool {
+	return RoleAtLeast(u.Role, required)
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// Session is one login on one device. It is also the family of every refresh
+// token issued for that login: each refresh rotates to a new token, and
+// presenting a rotated token again ends the whole session. Sessions a user
+// granted to a third-party app carry its ClientId.
+type Session struct {
+	Id         int       `json:"id"`
+	UserId     int       `json:"user_id"`
+	Device     string    `json:"device,omitempty"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Scope      string    `json:"scope,omitempty"`
+	ClientId   string    `json:"client_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// RefreshToken is stored by hash only. RotatedAt is set once it has been
+// exchanged for its successor.
+type RefreshToken struct {
+	Id        int        `json:"id"`
+	SessionId int        `json:"session_id"`
+	TokenHash string     `json:"token_hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+}
+
+type SessionResponse struct {
+	Id         int       `json:"id"`
+	Device     string    `json:"device,omitempty"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Scope      string    `json:"scope,omitempty"`
+	ClientId   string    `json:"client_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func (s *Session) Response() SessionResponse {
+	return SessionResponse{
+		Id:         s.Id,
+		Device:     s.Device,
+		IP:         s.IP,
+		UserAgent:  s.UserAgent,
+		Scope:      s.Scope,
+		ClientId:   s.ClientId,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		ExpiresAt:  s.ExpiresAt,
+	}
+}
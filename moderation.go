@@ -0,0 +1,297 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxReportReasonLength = 500
+
+type reportRequest struct {
+	Reason string `json:"reason"`
+}
+
+type resolveRequest struct {
+	Resolution string `json:"resolution"`
+}
+
+func (cfg *apiConfig) reportChirp(w http.ResponseWriter, r *http.Request) {
+	reporterID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	var request reportRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding report")
+		return
+	}
+
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" || len(request.Reason) > maxReportReasonLength {
+		respondWithError(w, http.StatusBadRequest, "reason is required and must be at most 500 characters")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	reporter, err := db.GetUser(reporterID)
+	if err != nil || reporter.Suspended || reporter.Deleted() {
+		respondWithError(w, http.StatusForbidden, "Your account can't report chirps")
+		return
+	}
+
+	chirp, err := db.GetChirp(chirpID)
+	if err != nil || !cfg.newChirpFilter(r, db).visible(chirp) {
+		respondWithError(w, http.StatusNotFound, "chirp not found")
+		return
+	}
+
+	if chirp.AuthorId == reporterID {
+		respondWithError(w, http.StatusBadRequest, "You can't report your own chirp")
+		return
+	}
+
+	report, err := db.CreateReport(chirpID, reporterID, request.Reason)
+	if errors.Is(err, database.ErrConflict) {
+		respondWithError(w, http.StatusConflict, "You have already reported this chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error writing database")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, report)
+}
+
+func (cfg *apiConfig) listReports(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	switch status {
+	case "", models.ReportStatusOpen, models.ReportStatusClaimed, models.ReportStatusResolved:
+	default:
+		respondWithError(w, http.StatusBadRequest, "status must be one of open, claimed or resolved")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	reports, err := db.GetReports(status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error loading reports")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, reports)
+}
+
+func (cfg *apiConfig) claimReport(w http.ResponseWriter, r *http.Request) {
+	moderatorID, reportID, ok := moderationTarget(w, r, "reportID")
+	if !ok {
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	report, err := db.ClaimReport(reportID, moderatorID)
+	if err != nil {
+		respondWithModerationError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+func (cfg *apiConfig) resolveReport(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		moderatorID, reportID, ok := moderationTarget(w, r, "reportID")
+		if !ok {
+			return
+		}
+
+		var request resolveRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Error decoding resolution")
+				return
+			}
+		}
+
+		db, err := database.NewDB("database.json")
+		if err != nil {
+			fmt.Printf("Error opening database: %v\n", err)
+		}
+
+		report, err := db.ResolveReport(reportID, moderatorID, action, strings.TrimSpace(request.Resolution))
+		if err != nil {
+			respondWithModerationError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, report)
+	}
+}
+
+func (cfg *apiConfig) suspendUser(suspended bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		moderatorID, userID, ok := moderationTarget(w, r, "userID")
+		if !ok {
+			return
+		}
+
+		if userID == moderatorID {
+			respondWithError(w, http.StatusBadRequest, "You can't suspend yourself")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+		if err != nil {
+			fmt.Printf("Error opening database: %v\n", err)
+		}
+
+		user, err := db.SetUserSuspended(userID, moderatorID, suspended)
+		if errors.Is(err, database.ErrConflict) {
+			respondWithError(w, http.StatusForbidden, "You can only suspend users with a role below yours")
+			return
+		}
+		if err != nil {
+			respondWithModerationError(w, err)
+			return
+		}
+
+		if suspended {
+			err = db.RevokeSessionsOfUser(userID)
+			if err != nil {
+				fmt.Printf("Error revoking sessions of user %d: %v\n", userID, err)
+			}
+			cfg.revokeTokensOfUser(db, userID)
+		}
+
+		respondWithJSON(w, http.StatusOK, user.Response())
+	}
+}
+
+// unlockUser lifts a lockout from failed logins before it runs out.
+func (cfg *apiConfig) unlockUser(w http.ResponseWriter, r *http.Request) {
+	moderatorID, userID, ok := moderationTarget(w, r, "userID")
+	if !ok {
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.UnlockUser(userID, moderatorID)
+	if err != nil {
+		respondWithModerationError(w, err)
+		return
+	}
+
+	cfg.loginGuard.emails.reset(user.Email)
+
+	respondWithJSON(w, http.StatusOK, user.Response())
+}
+
+type setRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func (cfg *apiConfig) setUserRole(w http.ResponseWriter, r *http.Request) {
+	adminID, userID, ok := moderationTarget(w, r, "userID")
+	if !ok {
+		return
+	}
+
+	var request setRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !models.ValidRole(request.Role) {
+		respondWithError(w, http.StatusBadRequest, "role must be user, moderator or admin")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.SetUserRole(userID, request.Role, adminID)
+	if errors.Is(err, database.ErrConflict) {
+		respondWithError(w, http.StatusConflict, "You can't change your own role")
+		return
+	}
+	if err != nil {
+		respondWithModerationError(w, err)
+		return
+	}
+
+	// Tokens carry the role, so the old ones must go.
+	cfg.revokeTokensOfUser(db, userID)
+
+	respondWithJSON(w, http.StatusOK, user.Response())
+}
+
+func (cfg *apiConfig) listAuditLog(w http.ResponseWriter, r *http.Request) {
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	entries, err := db.GetAuditLog()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error loading audit log")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+func moderationTarget(w http.ResponseWriter, r *http.Request, pathParam string) (int, int, bool) {
+	moderatorID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return 0, 0, false
+	}
+
+	targetID, err := strconv.Atoi(r.PathValue(pathParam))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid "+pathParam)
+		return 0, 0, false
+	}
+
+	return moderatorID, targetID, true
+}
+
+func respondWithModerationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		respondWithError(w, http.StatusNotFound, "not found")
+	case errors.Is(err, database.ErrConflict):
+		respondWithError(w, http.StatusConflict, "report is resolved or claimed by another moderator")
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Error writing database")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestModerationActsOnlyOnLowerRoles(t *testing.T) {
+	cfg := newTestConfig(t, "http://chirpy.test")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", cfg.login)
+	mux.HandleFunc("POST /admin/users/{userID}/suspend", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleModerator, cfg.suspendUser(true))))
+	mux.HandleFunc("POST /admin/users/{userID}/unsuspend", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleModerator, cfg.suspendUser(false))))
+	mux.HandleFunc("POST /admin/users/{userID}/unlock", cfg.requireScope(scopeAdmin, cfg.requireRole(models.RoleAdmin, cfg.unlockUser)))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := map[string]*models.User{}
+	tokens := map[string]string{}
+	for _, role := range []string{models.RoleUser, models.RoleModerator, "other-moderator", models.RoleAdmin} {
+		user := createTestUser(t, role+"@example.com")
+		if role != models.RoleUser {
+			userRole := role
+			if role == "other-moderator" {
+				userRole = models.RoleModerator
+			}
+			if _, err := db.SetUserRole(user.Id, userRole, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		users[role] = user
+		tokens[role] = loginTestUser(t, server.URL, user.Email).Token
+	}
+
+	tests := []struct {
+		actor  string
+		action string
+		target string
+		status int
+	}{
+		{models.RoleModerator, "suspend", models.RoleUser, http.StatusOK},
+		{models.RoleModerator, "unsuspend", models.RoleUser, http.StatusOK},
+		{models.RoleModerator, "suspend", "other-moderator", http.StatusForbidden},
+		{models.RoleModerator, "unsuspend", "other-moderator", http.StatusForbidden},
+		{models.RoleModerator, "suspend", models.RoleAdmin, http.StatusForbidden},
+		{models.RoleModerator, "unlock", models.RoleUser, http.StatusForbidden},
+		{models.RoleAdmin, "unlock", models.RoleUser, http.StatusOK},
+		{models.RoleAdmin, "suspend", "other-moderator", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		target := fmt.Sprintf("%s/admin/users/%d/%s", server.URL, users[test.target].Id, test.action)
+		resp := sendRequest(t, http.MethodPost, target, tokens[test.actor], nil, nil)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %s %s: got status %d, want %d", test.actor, test.action, test.target, resp.StatusCode, test.status)
+		}
+	}
+
+	moderator, err := db.GetUser(users["other-moderator"].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !moderator.Suspended {
+		t.Errorf("the admin didn't suspend the moderator")
+	}
+
+	sessions, err := db.GetSessionsOfUser(moderator.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("the suspended moderator has %d sessions left, want none", len(sessions))
+	}
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	authorizationCodeTTL = 10 * time.Minute
+	maxOAuthClients      = 10
+	maxRedirectURIs      = 10
+	maxOAuthClientName   = 50
+)
+
+var (
+	codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+	codeVerifierPattern  = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+)
+
+type registerOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"`
+}
+
+type oauthClientsResponse struct {
+	Clients []models.OAuthClientResponse `json:"clients"`
+}
+
+// oauthError is an error response as RFC 6749 section 5.2 describes it.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+type consentResponse struct {
+	ClientId    string `json:"client_id"`
+	ClientName  string `json:"client_name"`
+	RedirectURI string `json:"redirect_uri"`
+	Scope       string `json:"scope"`
+	State       string `json:"state,omitempty"`
+}
+
+type authorizationResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// authorizationRequest is a checked request of a client to act for the user.
+type authorizationRequest struct {
+	client        *models.OAuthClient
+	redirectURI   string
+	scope         string
+	state         string
+	codeChallenge string
+}
+
+func (cfg *apiConfig) registerOAuthClient(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	var request registerOAuthClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxOAuthClientName {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("name must be 1 to %d characters", maxOAuthClientName))
+		return
+	}
+
+	if len(request.RedirectURIs) == 0 || len(request.RedirectURIs) > maxRedirectURIs {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("redirect_uris must hold 1 to %d URIs", maxRedirectURIs))
+		return
+	}
+	redirectURIs := []string{}
+	for _, redirectURI := range request.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid redirect URI %q", redirectURI))
+			return
+		}
+		if !slices.Contains(redirectURIs, redirectURI) {
+			redirectURIs = append(redirectURIs, redirectURI)
+		}
+	}
+
+	clientID, err := newSecretToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error registering client")
+		return
+	}
+
+	client := models.OAuthClient{
+		ClientId:     clientID[:32],
+		OwnerId:      userID,
+		Name:         name,
+		RedirectURIs: redirectURIs,
+	}
+
+	secret := ""
+	if request.Confidential {
+		secret, err = newSecretToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error registering client")
+			return
+		}
+		client.SecretHash = hashToken(secret)
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	created, err := db.CreateOAuthClient(client, maxOAuthClients)
+	if errors.Is(err, database.ErrConflict) {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("You can register at most %d apps", maxOAuthClients))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error registering client")
+		return
+	}
+
+	response := created.Response()
+	response.ClientSecret = secret
+
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) listOAuthClients(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	clients, err := db.GetOAuthClientsOfUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error loading clients")
+		return
+	}
+
+	response := oauthClientsResponse{Clients: make([]models.OAuthClientResponse, 0, len(clients))}
+	for _, client := range clients {
+		response.Clients = append(response.Clients, client.Response())
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) deleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	err = db.DeleteOAuthClient(userID, r.PathValue("clientID"))
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "client not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error deleting client")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getAuthorization describes an authorization request for the consent screen.
+func (cfg *apiConfig) getAuthorization(w http.ResponseWriter, r *http.Request) {
+	db, user, ok := loadRequestUser(w, r)
+	if !ok {
+		return
+	}
+
+	request, oauthErr := parseAuthorizationRequest(db, user, r.URL.Query())
+	if oauthErr != nil {
+		respondWithJSON(w, http.StatusBadRequest, oauthErr)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, consentResponse{
+		ClientId:    request.client.ClientId,
+		ClientName:  request.client.Name,
+		RedirectURI: request.redirectURI,
+		Scope:       request.scope,
+		State:       request.state,
+	})
+}
+
+// authorize records the user's decision on an authorization request. The
+// answer is the URL to send the browser back to the client with, carrying
+// either the code or the error.
+func (cfg *apiConfig) authorize(w http.ResponseWriter, r *http.Request) {
+	db, user, ok := loadRequestUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+		return
+	}
+
+	request, oauthErr := parseAuthorizationRequest(db, user, r.Form)
+	if request == nil {
+		respondWithJSON(w, http.StatusBadRequest, oauthErr)
+		return
+	}
+	if oauthErr == nil && r.Form.Get("decision") != "allow" {
+		oauthErr = &oauthError{Code: "access_denied", Description: "The user denied the request"}
+	}
+	if oauthErr != nil {
+		respondWithJSON(w, http.StatusOK, authorizationResponse{RedirectTo: request.redirect(url.Values{
+			"error":             {oauthErr.Code},
+			"error_description": {oauthErr.Description},
+		})})
+		return
+	}
+
+	code, err := newSecretToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating authorization code")
+		return
+	}
+
+	err = db.CreateOAuthCode(models.OAuthCode{
+		CodeHash:      hashToken(code),
+		ClientId:      request.client.ClientId,
+		UserId:        user.Id,
+		RedirectURI:   request.redirectURI,
+		Scope:         request.scope,
+		CodeChallenge: request.codeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating authorization code")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, authorizationResponse{RedirectTo: request.redirect(url.Values{
+		"code": {code},
+	})})
+}
+
+// oauthToken is the token endpoint. It takes form encoded requests and
+// answers in the format of RFC 6749, not with our usual error body.
+func (cfg *apiConfig) oauthToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Error decoding request body")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	client, ok := authenticateOAuthClient(w, r, db)
+	if !ok {
+		return
+	}
+
+	newRefreshToken, err := newSecretToken()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Error creating refresh token")
+		return
+	}
+
+	var session *models.Session
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		verifier := r.PostForm.Get("code_verifier")
+		if !codeVerifierPattern.MatchString(verifier) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "code_verifier must be 43 to 128 unreserved characters")
+			return
+		}
+
+		session, err = db.ExchangeOAuthCode(
+			hashToken(r.PostForm.Get("code")),
+			client.ClientId,
+			r.PostForm.Get("redirect_uri"),
+			codeChallengeS256(verifier),
+			models.Session{
+				Device:    truncate(client.Name, maxDeviceLength),
+				IP:        clientIP(r),
+				UserAgent: truncate(r.UserAgent(), maxUserAgentLength),
+				ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+			},
+			hashToken(newRefreshToken),
+		)
+		if errors.Is(err, database.ErrTokenReused) {
+			fmt.Printf("Authorization code of client %s reused from %s, session revoked\n", client.ClientId, clientIP(r))
+		}
+	case "refresh_token":
+		session, err = db.RotateRefreshToken(
+			hashToken(r.PostForm.Get("refresh_token")),
+			client.ClientId,
+			hashToken(newRefreshToken),
+			time.Now().UTC().Add(refreshTokenTTL),
+			clientIP(r),
+			truncate(r.UserAgent(), maxUserAgentLength),
+		)
+		if errors.Is(err, database.ErrTokenReused) {
+			fmt.Printf("Refresh token of client %s reused from %s, session revoked\n", client.ClientId, clientIP(r))
+		}
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "The grant is invalid, expired or was already used")
+		return
+	}
+
+	user, err := db.GetUser(session.UserId)
+	if err != nil || user.Suspended {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "The user can't be acted for")
+		return
+	}
+
+	scope, err := grantDelegatedScopes(user, session.Scope)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "None of the granted scopes is left")
+		return
+	}
+
+	claims := &accessClaims{
+		Scope:    scope,
+		ClientID: client.ClientId,
+	}
+
+	tokenString, err := cfg.issueAccessToken(user, claims)
+	if err != nil {
+		fmt.Println("Error signing token:", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Error signing token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  tokenString,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(claims.ExpiresAt.Sub(claims.IssuedAt.Time).Seconds()),
+		RefreshToken: newRefreshToken,
+		Scope:        scope,
+	})
+}
+
+// oauthRevoke ends the session of a refresh token as RFC 7009 describes.
+// Unknown tokens are answered with 200 too, so nothing can be learned.
+func (cfg *apiConfig) oauthRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Error decoding request body")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	client, ok := authenticateOAuthClient(w, r, db)
+	if !ok {
+		return
+	}
+
+	err = db.RevokeSessionByToken(hashToken(r.PostForm.Get("token")), client.ClientId)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Error revoking token")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveOAuthMetadata publishes the authorization server metadata (RFC 8414).
+func (cfg *apiConfig) serveOAuthMetadata(w http.ResponseWriter, r *http.Request) {
+	scopes := slices.DeleteFunc(append(slices.Clone(userScopes), scopeAdmin), func(scope string) bool {
+		return scope == scopeAccount
+	})
+
+	respondWithJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                cfg.baseURL,
+		"authorization_endpoint":                cfg.baseURL + "/oauth/authorize",
+		"token_endpoint":                        cfg.baseURL + "/oauth/token",
+		"revocation_endpoint":                   cfg.baseURL + "/oauth/revoke",
+		"jwks_uri":                              cfg.baseURL + "/.well-known/jwks.json",
+		"scopes_supported":                      scopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
+
+// parseAuthorizationRequest checks the parameters of an authorization request.
+// While the client or redirect URI is in doubt the request is nil, and the
+// error must be shown to the user instead of being sent to the redirect URI.
+func parseAuthorizationRequest(db *database.DB, user *models.User, values url.Values) (*authorizationRequest, *oauthError) {
+	client, err := db.GetOAuthClient(values.Get("client_id"))
+	if err != nil {
+		return nil, &oauthError{Code: "invalid_request", Description: "Unknown client_id"}
+	}
+
+	redirectURI := values.Get("redirect_uri")
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, &oauthError{Code: "invalid_request", Description: "redirect_uri isn't registered for this client"}
+	}
+
+	request := &authorizationRequest{
+		client:      client,
+		redirectURI: redirectURI,
+		state:       values.Get("state"),
+	}
+
+	if values.Get("response_type") != "code" {
+		return request, &oauthError{Code: "unsupported_response_type", Description: "response_type must be code"}
+	}
+
+	request.codeChallenge = values.Get("code_challenge")
+	if values.Get("code_challenge_method") != "S256" || !codeChallengePattern.MatchString(request.codeChallenge) {
+		return request, &oauthError{Code: "invalid_request", Description: "A code_challenge with code_challenge_method S256 is required"}
+	}
+
+	request.scope, err = grantDelegatedScopes(user, values.Get("scope"))
+	if err != nil {
+		return request, &oauthError{Code: "invalid_scope", Description: err.Error()}
+	}
+
+	return request, nil
+}
+
+func (request *authorizationRequest) redirect(params url.Values) string {
+	target, err := url.Parse(request.redirectURI)
+	if err != nil {
+		return request.redirectURI
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if request.state != "" {
+		query.Set("state", request.state)
+	}
+	target.RawQuery = query.Encode()
+
+	return target.String()
+}
+
+// authenticateOAuthClient identifies the client of a token or revocation
+// request, from HTTP Basic auth or the client_id and client_secret form
+// fields. Public clients send their client_id only.
+func authenticateOAuthClient(w http.ResponseWriter, r *http.Request, db *database.DB) (*models.OAuthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes both before Basic auth.
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := db.GetOAuthClient(clientID)
+	if err == nil && (!client.Confidential() || tokenMatchesHash(secret, client.SecretHash)) {
+		return client, true
+	}
+
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+
+	return nil, false
+}
+
+// loadRequestUser loads the user whose token authenticated the request.
+func loadRequestUser(w http.ResponseWriter, r *http.Request) (*database.DB, *models.User, bool) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return nil, nil, false
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return nil, nil, false
+	}
+
+	return db, user, true
+}
+
+// validRedirectURI accepts https URIs, http ones on the loopback interface and
+// the private-use schemes of native apps, such as com.example.app:/callback.
+func validRedirectURI(raw string) bool {
+	target, err := url.Parse(raw)
+	if err != nil || target.Scheme == "" || strings.Contains(raw, "#") {
+		return false
+	}
+
+	switch target.Scheme {
+	case "https":
+		return target.Host != ""
+	case "http":
+		host := target.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return strings.Contains(target.Scheme, ".")
+	}
+}
+
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, errorCode string, description string) {
+	respondWithJSON(w, code, oauthError{Code: errorCode, Description: description})
+}
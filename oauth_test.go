@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	testRedirectURI  = "https://app.example/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// oauthTestClient is a client registered by the user of token.
+type oauthTestClient struct {
+	server *httptest.Server
+	token  string
+	id     string
+	secret string
+}
+
+func newOAuthTestServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+
+	cfg := newTestConfig(t, "http://chirpy.test")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", cfg.login)
+	mux.HandleFunc("PUT /api/users/preferences", cfg.requireScope(scopeProfileWrite, cfg.updatePreferences))
+	mux.HandleFunc("POST /api/oauth/clients", cfg.requireScope(scopeAccount, cfg.registerOAuthClient))
+	mux.HandleFunc("GET /oauth/authorize", cfg.requireScope(scopeAccount, cfg.getAuthorization))
+	mux.HandleFunc("POST /oauth/authorize", cfg.requireScope(scopeAccount, cfg.authorize))
+	mux.HandleFunc("POST /oauth/token", cfg.oauthToken)
+	mux.HandleFunc("POST /oauth/revoke", cfg.oauthRevoke)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	createTestUser(t, "walt@example.com")
+	login := loginTestUser(t, server.URL, "walt@example.com")
+
+	return server, login.Token
+}
+
+func registerTestClient(t *testing.T, server *httptest.Server, token string) oauthTestClient {
+	t.Helper()
+
+	var registered struct {
+		ClientId     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}
+	resp := sendRequest(t, http.MethodPost, server.URL+"/api/oauth/clients", token, registerOAuthClientRequest{
+		Name:         "Test app",
+		RedirectURIs: []string{testRedirectURI},
+		Confidential: true,
+	}, &registered)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("registering client: status %d", resp.StatusCode)
+	}
+	if registered.ClientId == "" || registered.ClientSecret == "" {
+		t.Fatalf("registering client: got id %q and secret %q", registered.ClientId, registered.ClientSecret)
+	}
+
+	return oauthTestClient{server: server, token: token, id: registered.ClientId, secret: registered.ClientSecret}
+}
+
+func (c oauthTestClient) authorizationValues(scope string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.id},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {scope},
+		"state":                 {"xyz"},
+		"code_challenge":        {codeChallengeS256(testCodeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// authorize allows the request for scope and returns the query of the
+// redirect back to the client.
+func (c oauthTestClient) authorize(t *testing.T, scope string) url.Values {
+	t.Helper()
+
+	values := c.authorizationValues(scope)
+	values.Set("decision", "allow")
+
+	var response authorizationResponse
+	resp := sendRequest(t, http.MethodPost, c.server.URL+"/oauth/authorize", c.token, values, &response)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("authorizing: status %d", resp.StatusCode)
+	}
+
+	if !strings.HasPrefix(response.RedirectTo, testRedirectURI+"?") {
+		t.Fatalf("authorizing: redirect to %q", response.RedirectTo)
+	}
+	redirect, err := url.Parse(response.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return redirect.Query()
+}
+
+// post sends a form to the token or revocation endpoint, authenticated as
+// the client with HTTP Basic auth.
+func (c oauthTestClient) post(t *testing.T, path string, values url.Values, response any) *http.Response {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodPost, c.server.URL+path, strings.NewReader(values.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(url.QueryEscape(c.id), url.QueryEscape(c.secret))
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if response != nil {
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			t.Fatalf("POST %s: decoding response with status %d: %v", path, resp.StatusCode, err)
+		}
+	}
+
+	return resp
+}
+
+func (c oauthTestClient) exchangeCode(t *testing.T, code string, verifier string, response any) *http.Response {
+	t.Helper()
+
+	return c.post(t, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {verifier},
+	}, response)
+}
+
+func (c oauthTestClient) refresh(t *testing.T, refreshToken string, response any) *http.Response {
+	t.Helper()
+
+	return c.post(t, "/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}, response)
+}
+
+func expectOAuthError(t *testing.T, resp *http.Response, got oauthError, status int, code string) {
+	t.Helper()
+
+	if resp.StatusCode != status || got.Code != code {
+		t.Fatalf("got status %d and error %q, want %d and %q", resp.StatusCode, got.Code, status, code)
+	}
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	server, token := newOAuthTestServer(t)
+	client := registerTestClient(t, server, token)
+
+	var consent consentResponse
+	resp := sendRequest(t, http.MethodGet, server.URL+"/oauth/authorize?"+client.authorizationValues("profile:write").Encode(), token, nil, &consent)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("consent: status %d", resp.StatusCode)
+	}
+	if consent.ClientId != client.id || consent.ClientName != "Test app" || consent.Scope != "profile:write" || consent.State != "xyz" {
+		t.Fatalf("consent: got %+v", consent)
+	}
+
+	query := client.authorize(t, "profile:write")
+	if query.Get("state") != "xyz" {
+		t.Fatalf("authorizing: got state %q", query.Get("state"))
+	}
+
+	var tokens oauthTokenResponse
+	resp = client.exchangeCode(t, query.Get("code"), testCodeVerifier, &tokens)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("exchanging code: status %d", resp.StatusCode)
+	}
+	if tokens.TokenType != "Bearer" || tokens.Scope != "profile:write" || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("exchanging code: got %+v", tokens)
+	}
+
+	resp = sendRequest(t, http.MethodPut, server.URL+"/api/users/preferences", tokens.AccessToken, map[string]string{"sensitive_content": "hide"}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("using access token: status %d", resp.StatusCode)
+	}
+
+	var refreshed oauthTokenResponse
+	resp = client.refresh(t, tokens.RefreshToken, &refreshed)
+	if resp.StatusCode != http.StatusOK || refreshed.RefreshToken == tokens.RefreshToken {
+		t.Fatalf("refreshing: status %d, got %+v", resp.StatusCode, refreshed)
+	}
+
+	resp = client.post(t, "/oauth/revoke", url.Values{"token": {refreshed.RefreshToken}}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("revoking: status %d", resp.StatusCode)
+	}
+
+	var oauthErr oauthError
+	resp = client.refresh(t, refreshed.RefreshToken, &oauthErr)
+	expectOAuthError(t, resp, oauthErr, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestOAuthRejectsWrongCodeVerifier(t *testing.T) {
+	server, token := newOAuthTestServer(t)
+	client := registerTestClient(t, server, token)
+
+	query := client.authorize(t, "profile:write")
+
+	var oauthErr oauthError
+	resp := client.exchangeCode(t, query.Get("code"), strings.Repeat("a", 43), &oauthErr)
+	expectOAuthError(t, resp, oauthErr, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestOAuthRejectsReusedCode(t *testing.T) {
+	server, token := newOAuthTestServer(t)
+	client := registerTestClient(t, server, token)
+
+	query := client.authorize(t, "profile:write")
+
+	var tokens oauthTokenResponse
+	resp := client.exchangeCode(t, query.Get("code"), testCodeVerifier, &tokens)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("exchanging code: status %d", resp.StatusCode)
+	}
+
+	var oauthErr oauthError
+	resp = client.exchangeCode(t, query.Get("code"), testCodeVerifier, &oauthErr)
+	expectOAuthError(t, resp, oauthErr, http.StatusBadRequest, "invalid_grant")
+
+	// A reused code revokes the session it was first exchanged for.
+	oauthErr = oauthError{}
+	resp = client.refresh(t, tokens.RefreshToken, &oauthErr)
+	expectOAuthError(t, resp, oauthErr, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestOAuthRejectsScopesBeyondGrant(t *testing.T) {
+	server, token := newOAuthTestServer(t)
+	client := registerTestClient(t, server, token)
+
+	for _, scope := range []string{"admin", "account", "chirps:delete account"} {
+		values := client.authorizationValues(scope)
+		values.Set("decision", "allow")
+
+		var response authorizationResponse
+		resp := sendRequest(t, http.MethodPost, server.URL+"/oauth/authorize", token, values, &response)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("authorizing %q: status %d", scope, resp.StatusCode)
+		}
+		redirect, err := url.Parse(response.RedirectTo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redirect.Query().Get("error"); got != "invalid_scope" || redirect.Query().Has("code") {
+			t.Fatalf("authorizing %q: redirect to %q, want error invalid_scope", scope, response.RedirectTo)
+		}
+	}
+
+	// Scopes the user doesn't hold are left out when others can be granted,
+	// and the token can't be used beyond what was granted.
+	query := client.authorize(t, "chirps:write admin")
+
+	var tokens oauthTokenResponse
+	resp := client.exchangeCode(t, query.Get("code"), testCodeVerifier, &tokens)
+	if resp.StatusCode != http.StatusOK || tokens.Scope != "chirps:write" {
+		t.Fatalf("exchanging code: status %d, got %+v", resp.StatusCode, tokens)
+	}
+
+	resp = sendRequest(t, http.MethodPut, server.URL+"/api/users/preferences", tokens.AccessToken, map[string]string{"sensitive_content": "hide"}, nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("using a chirps:write token on a profile:write route: status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
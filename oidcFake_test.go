@@ -0,0 +1,212 @@
+package main
+
+import (
+	"Chirpy/oidc"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const fakeOIDCKid = "fake"
+
+// fakeOIDC is an OpenID provider for tests. Whoever reaches its
+// authorization endpoint is signed in at once as the login_hint email. It
+// checks the client, redirect URI and PKCE like a real provider, so the
+// relying party goes through the whole flow.
+type fakeOIDC struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	key          ed25519.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]fakeOIDCCode
+	// tamper, when set, changes the claims before the ID token is signed.
+	tamper func(*oidc.Claims)
+}
+
+type fakeOIDCCode struct {
+	email         string
+	nonce         string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+func newFakeOIDC(issuer string, redirectURL string) *fakeOIDC {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return &fakeOIDC{
+		issuer:       issuer,
+		clientID:     "chirpy",
+		clientSecret: "fake-secret",
+		redirectURL:  redirectURL,
+		key:          key,
+		codes:        make(map[string]fakeOIDCCode),
+	}
+}
+
+// provider is the relying party configuration for the fake.
+func (f *fakeOIDC) provider() *oidc.Provider {
+	return oidc.New(oidc.Config{
+		Issuer:       f.issuer,
+		ClientID:     f.clientID,
+		ClientSecret: f.clientSecret,
+		RedirectURL:  f.redirectURL,
+	})
+}
+
+func (f *fakeOIDC) setTamper(tamper func(*oidc.Claims)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tamper = tamper
+}
+
+func (f *fakeOIDC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		respondWithJSON(w, http.StatusOK, map[string]any{
+			"issuer":                                f.issuer,
+			"authorization_endpoint":                f.issuer + "/authorize",
+			"token_endpoint":                        f.issuer + "/token",
+			"jwks_uri":                              f.issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"EdDSA"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	case "/jwks":
+		respondWithJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kty": "OKP",
+			"kid": fakeOIDCKid,
+			"use": "sig",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(f.key.Public().(ed25519.PublicKey)),
+		}}})
+	case "/authorize":
+		f.authorize(w, r)
+	case "/token":
+		f.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != f.clientID || query.Get("redirect_uri") != f.redirectURL {
+		http.Error(w, "unknown client or redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	target, _ := url.Parse(f.redirectURL)
+	params := target.Query()
+	params.Set("state", query.Get("state"))
+
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		params.Set("error", "invalid_request")
+	} else {
+		code, err := newSecretToken()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		f.mu.Lock()
+		f.codes[code] = fakeOIDCCode{
+			email:         strings.ToLower(query.Get("login_hint")),
+			nonce:         query.Get("nonce"),
+			codeChallenge: query.Get("code_challenge"),
+			expiresAt:     time.Now().Add(time.Minute),
+		}
+		f.mu.Unlock()
+
+		params.Set("code", code)
+	}
+
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (f *fakeOIDC) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, oauthError{Code: "invalid_request"})
+		return
+	}
+
+	clientID, secret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	secret, _ = url.QueryUnescape(secret)
+	if clientID != f.clientID || secret != f.clientSecret {
+		respondWithJSON(w, http.StatusUnauthorized, oauthError{Code: "invalid_client"})
+		return
+	}
+
+	f.mu.Lock()
+	code, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	tamper := f.tamper
+	f.mu.Unlock()
+
+	if !ok || time.Now().After(code.expiresAt) ||
+		r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("redirect_uri") != f.redirectURL ||
+		codeChallengeS256(r.PostForm.Get("code_verifier")) != code.codeChallenge {
+		respondWithJSON(w, http.StatusBadRequest, oauthError{Code: "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := oidc.Claims{
+		Email:         code.email,
+		EmailVerified: true,
+		Name:          strings.Split(code.email, "@")[0],
+		Nonce:         code.nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    f.issuer,
+			Subject:   fakeOIDCSubject(code.email),
+			Audience:  jwt.ClaimStrings{f.clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+	if tamper != nil {
+		tamper(&claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = fakeOIDCKid
+
+	idToken, err := token.SignedString(f.key)
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, oauthError{Code: "server_error"})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]any{
+		"access_token": "fake-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// fakeOIDCSubject is the subject the fake gives email. Real providers use
+// opaque subjects, so the relying party mustn't take the email for one.
+func fakeOIDCSubject(email string) string {
+	subject := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(subject[:8])
+}
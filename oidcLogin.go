@@ -0,0 +1,275 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"Chirpy/oidc"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcStateCookie ties a login to the browser that started it, holding the
+// hash of its state. Without it anyone could send a victim the callback URL
+// of a login they started and sign the victim in as themselves.
+const oidcStateCookie = "chirpy_oidc_state"
+
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// pendingOIDCLogin is what we remember about a login between sending the
+// browser to the provider and its return, looked up by the state parameter.
+type pendingOIDCLogin struct {
+	provider     string
+	nonce        string
+	codeVerifier string
+	device       string
+	scope        string
+	cookie       bool
+	expiresAt    time.Time
+}
+
+// oidcLogins keeps pending logins in memory; a restart only means starting
+// the login again.
+type oidcLogins struct {
+	mu      sync.Mutex
+	pending map[string]pendingOIDCLogin
+}
+
+func newOIDCLogins() *oidcLogins {
+	return &oidcLogins{pending: make(map[string]pendingOIDCLogin)}
+}
+
+func (l *oidcLogins) add(state string, login pendingOIDCLogin) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, pending := range l.pending {
+		if now.After(pending.expiresAt) {
+			delete(l.pending, key)
+		}
+	}
+
+	l.pending[state] = login
+}
+
+// take returns a pending login once; the state can't be replayed.
+func (l *oidcLogins) take(state string) (pendingOIDCLogin, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	login, ok := l.pending[state]
+	delete(l.pending, state)
+	if !ok || time.Now().After(login.expiresAt) {
+		return pendingOIDCLogin{}, false
+	}
+
+	return login, true
+}
+
+// oidcProvidersFromEnv reads the providers named in OIDC_PROVIDERS, e.g.
+// "corp", each configured by OIDC_CORP_ISSUER, OIDC_CORP_CLIENT_ID,
+// OIDC_CORP_CLIENT_SECRET and optionally OIDC_CORP_SCOPES.
+func oidcProvidersFromEnv(baseURL string) (map[string]*oidc.Provider, error) {
+	providers := make(map[string]*oidc.Provider)
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !oidcProviderName.MatchString(name) {
+			return nil, fmt.Errorf("invalid OIDC provider name %q", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		config := oidc.Config{
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  baseURL + "/api/oidc/" + name + "/callback",
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+
+		providers[name] = oidc.New(config)
+	}
+
+	return providers, nil
+}
+
+// startOIDCLogin sends the browser to the provider's sign-in page.
+func (cfg *apiConfig) startOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := cfg.oidcProviders[name]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown provider")
+		return
+	}
+
+	state, errState := newSecretToken()
+	nonce, errNonce := newSecretToken()
+	codeVerifier, errVerifier := newSecretToken()
+	if err := errors.Join(errState, errNonce, errVerifier); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting login")
+		return
+	}
+
+	query := r.URL.Query()
+	target, err := provider.AuthCodeURL(r.Context(), state, nonce, codeChallengeS256(codeVerifier), query.Get("login_hint"))
+	if err != nil {
+		fmt.Printf("Error contacting OIDC provider %s: %v\n", name, err)
+		respondWithError(w, http.StatusBadGateway, "The provider can't be reached")
+		return
+	}
+
+	// Lax, not Strict: the browser comes back from the provider's site.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    hashToken(state),
+		Path:     "/api/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	cfg.oidcLogins.add(state, pendingOIDCLogin{
+		provider:     name,
+		nonce:        nonce,
+		codeVerifier: codeVerifier,
+		device:       query.Get("device"),
+		scope:        query.Get("scope"),
+		cookie:       query.Get("cookie") == "true",
+		expiresAt:    time.Now().Add(oidcLoginTTL),
+	})
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// finishOIDCLogin is where the provider sends the browser back to. It
+// answers like POST /api/login.
+func (cfg *apiConfig) finishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := cfg.oidcProviders[name]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown provider")
+		return
+	}
+
+	stateCookie, err := r.Cookie(oidcStateCookie)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	if err != nil || !tokenMatchesHash(query.Get("state"), stateCookie.Value) {
+		respondWithError(w, http.StatusBadRequest, "The login wasn't started in this browser, please start again")
+		return
+	}
+
+	login, ok := cfg.oidcLogins.take(query.Get("state"))
+	if !ok || login.provider != name {
+		respondWithError(w, http.StatusBadRequest, "The login expired or is unknown, please start again")
+		return
+	}
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		respondWithError(w, http.StatusUnauthorized, fmt.Sprintf("The provider refused the login: %s", providerErr))
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), login.codeVerifier, login.nonce)
+	if err != nil {
+		fmt.Printf("Error finishing OIDC login with %s: %v\n", name, err)
+		respondWithError(w, http.StatusUnauthorized, "Signing in with the provider failed")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, status, err := cfg.oidcUser(db, name, claims)
+	if err != nil {
+		respondWithError(w, status, err.Error())
+		return
+	}
+
+	if user.Suspended {
+		respondWithError(w, http.StatusForbidden, "This account is suspended")
+		return
+	}
+
+	scope, err := grantScopes(user, login.scope)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		cfg.startLoginChallenge(w, db, user, login.device, scope, login.cookie)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, db, user, login.device, scope, login.cookie)
+}
+
+// oidcUser finds the user an external account is linked to. The first login
+// links it by the verified email, or registers a new user, whose password is
+// random until they set one with a password reset.
+func (cfg *apiConfig) oidcUser(db *database.DB, provider string, claims *oidc.Claims) (*models.User, int, error) {
+	user, err := db.GetUserByExternalIdentity(provider, claims.Subject)
+	if err == nil {
+		return user, 0, nil
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return nil, http.StatusInternalServerError, errors.New("Error loading user")
+	}
+
+	if !claims.EmailVerified || models.ValidateEmail(claims.Email) != nil {
+		return nil, http.StatusForbidden, errors.New("The provider didn't confirm an email address")
+	}
+
+	user, err = db.GetUserByEmail(claims.Email)
+	if err == nil {
+		user, err = db.LinkExternalIdentity(user.Id, provider, claims.Subject)
+		if err != nil {
+			return nil, http.StatusConflict, errors.New("This account is linked to another user")
+		}
+
+		return user, 0, nil
+	}
+
+	randomPassword, err := newSecretToken()
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Error creating user")
+	}
+
+	passwordHash, err := cfg.passwordHasher.Hash(randomPassword)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Error creating user")
+	}
+
+	user, err = db.CreateExternalUser(claims.Email, passwordHash, provider, claims.Subject)
+	if err != nil {
+		return nil, http.StatusConflict, errors.New("This account is linked to another user")
+	}
+
+	return user, 0, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"Chirpy/oidc"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newOIDCTestServer serves the OIDC login routes with fakeOIDC mounted as the
+// fake provider under /fake-oidc.
+func newOIDCTestServer(t *testing.T) (*httptest.Server, *fakeOIDC) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	cfg := newTestConfig(t, server.URL)
+	fake := newFakeOIDC(server.URL+"/fake-oidc", server.URL+"/api/oidc/fake/callback")
+	cfg.oidcProviders["fake"] = fake.provider()
+
+	mux.Handle("/fake-oidc/", http.StripPrefix("/fake-oidc", fake))
+	mux.HandleFunc("GET /api/oidc/{provider}/login", cfg.startOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/{provider}/callback", cfg.finishOIDCLogin)
+
+	return server, fake
+}
+
+// startFakeLogin follows the browser through the login as email up to the
+// callback. It returns the callback URL and the state cookie the browser got.
+func startFakeLogin(t *testing.T, server *httptest.Server, email string) (string, *http.Cookie) {
+	t.Helper()
+
+	resp := sendRequest(t, http.MethodGet, server.URL+"/api/oidc/fake/login?login_hint="+url.QueryEscape(email), "", nil, nil)
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(location, server.URL+"/fake-oidc/authorize?") {
+		t.Fatalf("starting login: status %d, redirect to %q", resp.StatusCode, location)
+	}
+
+	var stateCookie *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidcStateCookie {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly || stateCookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("starting login: got state cookie %v, want an HttpOnly SameSite=Lax one", stateCookie)
+	}
+
+	resp = sendRequest(t, http.MethodGet, location, "", nil, nil)
+	location = resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(location, server.URL+"/api/oidc/fake/callback?") {
+		t.Fatalf("signing in at the provider: status %d, redirect to %q", resp.StatusCode, location)
+	}
+
+	return location, stateCookie
+}
+
+// finishFakeLogin sends the browser to the callback, with the state cookie
+// unless it is nil, and decodes the answer into response.
+func finishFakeLogin(t *testing.T, callback string, stateCookie *http.Cookie, response any) *http.Response {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodGet, callback, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stateCookie != nil {
+		request.AddCookie(&http.Cookie{Name: stateCookie.Name, Value: stateCookie.Value})
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		t.Fatalf("callback: decoding response with status %d: %v", resp.StatusCode, err)
+	}
+
+	return resp
+}
+
+// signInWithFake goes through the whole login as email in one browser and
+// returns the answer of the callback.
+func signInWithFake(t *testing.T, server *httptest.Server, email string, response any) *http.Response {
+	t.Helper()
+
+	callback, stateCookie := startFakeLogin(t, server, email)
+	return finishFakeLogin(t, callback, stateCookie, response)
+}
+
+func TestOIDCLoginLinksUsers(t *testing.T) {
+	server, _ := newOIDCTestServer(t)
+	existing := createTestUser(t, "walt@example.com")
+
+	var login models.APIUserResponse
+	resp := signInWithFake(t, server, "Walt@Example.com", &login)
+	if resp.StatusCode != http.StatusOK || login.Token == "" {
+		t.Fatalf("signing in: status %d, got %+v", resp.StatusCode, login)
+	}
+	if login.Id != existing.Id {
+		t.Fatalf("signing in: got user %d, want the existing user %d", login.Id, existing.Id)
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	linked, err := db.GetUserByExternalIdentity("fake", fakeOIDCSubject("walt@example.com"))
+	if err != nil || linked.Id != existing.Id {
+		t.Fatalf("looking up the linked identity: got %v, %v", linked, err)
+	}
+
+	login = models.APIUserResponse{}
+	resp = signInWithFake(t, server, "walt@example.com", &login)
+	if resp.StatusCode != http.StatusOK || login.Id != existing.Id {
+		t.Fatalf("signing in again: status %d, got user %d", resp.StatusCode, login.Id)
+	}
+
+	login = models.APIUserResponse{}
+	resp = signInWithFake(t, server, "jesse@example.com", &login)
+	if resp.StatusCode != http.StatusOK || login.Id == existing.Id || login.Email != "jesse@example.com" {
+		t.Fatalf("signing in with a new email: status %d, got %+v", resp.StatusCode, login)
+	}
+}
+
+func TestOIDCLoginRejectsBadIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(*oidc.Claims)
+	}{
+		{"issuer", func(claims *oidc.Claims) {
+			claims.Issuer = "https://evil.example"
+		}},
+		{"audience", func(claims *oidc.Claims) {
+			claims.Audience = jwt.ClaimStrings{"another-client"}
+		}},
+		{"nonce", func(claims *oidc.Claims) {
+			claims.Nonce = "replayed"
+		}},
+		{"expiry", func(claims *oidc.Claims) {
+			claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Minute))
+		}},
+		{"missing expiry", func(claims *oidc.Claims) {
+			claims.ExpiresAt = nil
+		}},
+	}
+
+	server, fake := newOIDCTestServer(t)
+	existing := createTestUser(t, "walt@example.com")
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake.setTamper(test.tamper)
+			defer fake.setTamper(nil)
+
+			var response struct {
+				Error string `json:"error"`
+			}
+			resp := signInWithFake(t, server, existing.Email, &response)
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+			}
+
+			_, err := db.GetUserByExternalIdentity("fake", fakeOIDCSubject(existing.Email))
+			if !errors.Is(err, database.ErrNotFound) {
+				t.Fatalf("the identity was linked after a rejected login: %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginRequiresStateCookie(t *testing.T) {
+	server, _ := newOIDCTestServer(t)
+	createTestUser(t, "walt@example.com")
+
+	// An attacker's callback URL, opened in a browser that didn't start the
+	// login, must not sign that browser in.
+	callback, _ := startFakeLogin(t, server, "walt@example.com")
+
+	var response struct {
+		Error string `json:"error"`
+	}
+	resp := finishFakeLogin(t, callback, nil, &response)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("callback without the state cookie: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == accessCookie && cookie.Value != "" {
+			t.Fatal("callback without the state cookie set an access cookie")
+		}
+	}
+
+	// Another login's cookie doesn't fit either.
+	_, otherCookie := startFakeLogin(t, server, "walt@example.com")
+	callback, _ = startFakeLogin(t, server, "walt@example.com")
+	resp = finishFakeLogin(t, callback, otherCookie, &response)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("callback with another login's state cookie: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	var login models.APIUserResponse
+	callback, stateCookie := startFakeLogin(t, server, "walt@example.com")
+	resp = finishFakeLogin(t, callback, stateCookie, &login)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback with the state cookie: status %d", resp.StatusCode)
+	}
+
+	cleared := false
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidcStateCookie && cookie.MaxAge < 0 {
+			cleared = true
+		}
+	}
+	if !cleared {
+		t.Fatal("the callback didn't clear the state cookie")
+	}
+}
@@ -0,0 +1,62 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachList looks passwords up in a local copy of a k-anonymity breach
+// corpus laid out like the Pwned Passwords range API: one file per 5
+// character SHA-1 prefix, named after the prefix, holding "SUFFIX:COUNT"
+// lines. Only the prefix file is read, so the full hash is never needed in
+// one place and the store can later be swapped for a remote range API.
+type BreachList struct {
+	dir string
+}
+
+func NewBreachList(dir string) *BreachList {
+	if dir == "" {
+		return nil
+	}
+
+	return &BreachList{dir: dir}
+}
+
+func (b *BreachList) Contains(password string) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(b.dir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = os.Open(filepath.Join(b.dir, prefix+".txt"))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		candidate, _, _ := strings.Cut(line, ":")
+
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
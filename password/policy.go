@@ -0,0 +1,44 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// MaxLength keeps passwords within what bcrypt can hash.
+const MaxLength = 72
+
+var ErrBreached = errors.New("password appears in a known data breach, please choose another one")
+
+type Policy struct {
+	MinLength int
+	MinScore  int
+	Breached  *BreachList
+}
+
+// Check returns a user-facing error when the password doesn't meet the
+// policy. userInputs such as the email and handle count against strength.
+func (p Policy) Check(password string, userInputs ...string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+
+	if len(password) > MaxLength {
+		return fmt.Errorf("password must be at most %d bytes", MaxLength)
+	}
+
+	if Estimate(password, userInputs...) < p.MinScore {
+		return errors.New("password is too easy to guess, try a longer phrase or fewer common words")
+	}
+
+	breached, err := p.Breached.Contains(password)
+	if err != nil {
+		return err
+	}
+	if breached {
+		return ErrBreached
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords holds the most used passwords and words from leaks. Any
+// password built mostly from one of them is cheap to guess.
+var commonPasswords = []string{
+	"password", "123456", "qwerty", "letmein", "welcome", "monkey", "dragon",
+	"football", "baseball", "iloveyou", "admin", "login", "master", "sunshine",
+	"princess", "shadow", "superman", "michael", "trustno1", "starwars",
+	"whatever", "freedom", "hello", "secret", "chirpy", "passw0rd", "abc123",
+	"qazwsx", "zaq12wsx", "computer", "internet", "summer", "winter", "spring",
+	"autumn", "love", "batman", "pokemon", "soccer", "hockey", "jordan",
+	"harley", "ranger", "buster", "tigger", "charlie", "robert", "thomas",
+	"daniel", "jessica", "ashley", "hunter", "killer", "pepper", "ginger",
+	"cookie", "cheese", "flower", "orange", "banana", "purple", "google",
+}
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+var leetSubstitutions = strings.NewReplacer(
+	"0", "o", "1", "l", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i",
+)
+
+// Estimate scores a password from 0 (trivial) to 4 (very strong) the way
+// zxcvbn does: it estimates the number of guesses an attacker needs and buckets
+// it at 10^3, 10^6, 10^8 and 10^10. Patterns such as dictionary words, repeats,
+// sequences, keyboard walks and the user's own details count as a single
+// guessable token rather than as random characters.
+func Estimate(password string, userInputs ...string) int {
+	guesses := estimateGuessesLog10(password, userInputs)
+
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func estimateGuessesLog10(password string, userInputs []string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	lower := strings.ToLower(password)
+	unleeted := leetSubstitutions.Replace(lower)
+	covered := make([]bool, len(runes))
+	guesses := 0.0
+
+	dictionary := append([]string{}, commonPasswords...)
+	for _, input := range userInputs {
+		for _, part := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(part)) >= 3 {
+				dictionary = append(dictionary, part)
+			}
+		}
+	}
+
+	for _, word := range dictionary {
+		for _, candidate := range []string{lower, unleeted} {
+			index := strings.Index(candidate, word)
+			if index < 0 || len(candidate) != len(lower) {
+				continue
+			}
+
+			start := len([]rune(candidate[:index]))
+			if markCovered(covered, start, len([]rune(word))) {
+				guesses += math.Log10(float64(len(dictionary)) * 4)
+			}
+		}
+	}
+
+	for start := 0; start < len(runes); {
+		length := patternLength(runes, start)
+		if length >= 3 && markCovered(covered, start, length) {
+			guesses += math.Log10(float64(length) * 26)
+			start += length
+			continue
+		}
+
+		start++
+	}
+
+	charset := charsetSize(runes)
+	for i := range runes {
+		if !covered[i] {
+			guesses += math.Log10(float64(charset))
+		}
+	}
+
+	return guesses
+}
+
+// patternLength returns how many runes from start form a repeat, an
+// alphabetical or numeric sequence, or a walk along a keyboard row.
+func patternLength(runes []rune, start int) int {
+	best := 1
+
+	for _, step := range []func(previous rune, next rune) bool{
+		func(previous rune, next rune) bool { return unicode.ToLower(previous) == unicode.ToLower(next) },
+		func(previous rune, next rune) bool { return unicode.ToLower(next)-unicode.ToLower(previous) == 1 },
+		func(previous rune, next rune) bool { return unicode.ToLower(previous)-unicode.ToLower(next) == 1 },
+		adjacentOnKeyboard,
+	} {
+		length := 1
+		for start+length < len(runes) && step(runes[start+length-1], runes[start+length]) {
+			length++
+		}
+
+		if length > best {
+			best = length
+		}
+	}
+
+	return best
+}
+
+func adjacentOnKeyboard(previous rune, next rune) bool {
+	previous, next = unicode.ToLower(previous), unicode.ToLower(next)
+
+	for _, row := range keyboardRows {
+		index := strings.IndexRune(row, previous)
+		if index < 0 {
+			continue
+		}
+
+		if index+1 < len(row) && rune(row[index+1]) == next {
+			return true
+		}
+		if index > 0 && rune(row[index-1]) == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// markCovered claims the runes of a pattern. It returns false when most of
+// them already belong to another pattern.
+func markCovered(covered []bool, start int, length int) bool {
+	free := 0
+	for i := start; i < start+length && i < len(covered); i++ {
+		if !covered[i] {
+			free++
+		}
+	}
+
+	if free*2 < length {
+		return false
+	}
+
+	for i := start; i < start+length && i < len(covered); i++ {
+		covered[i] = true
+	}
+
+	return true
+}
+
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+
+	for _, r := range runes {
+		switch {
+		case r > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+
+	return size
+}
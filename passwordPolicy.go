@@ -0,0 +1,107 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"Chirpy/password"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func passwordPolicyFromEnv() (password.Policy, error) {
+	policy := password.Policy{
+		MinLength: 8,
+		MinScore:  2,
+		Breached:  password.NewBreachList(os.Getenv("BREACHED_PASSWORDS_DIR")),
+	}
+
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+		minLength, err := strconv.Atoi(value)
+		if err != nil || minLength < 1 || minLength > password.MaxLength {
+			return policy, fmt.Errorf("invalid PASSWORD_MIN_LENGTH %q", value)
+		}
+		policy.MinLength = minLength
+	}
+
+	if value := os.Getenv("PASSWORD_MIN_SCORE"); value != "" {
+		minScore, err := strconv.Atoi(value)
+		if err != nil || minScore < 0 || minScore > 4 {
+			return policy, fmt.Errorf("invalid PASSWORD_MIN_SCORE %q", value)
+		}
+		policy.MinScore = minScore
+	}
+
+	return policy, nil
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+
+	return *value
+}
+
+func passwordHasherFromEnv() (password.Hasher, error) {
+	switch algorithm := os.Getenv("PASSWORD_HASHER"); algorithm {
+	case "", "argon2id":
+		hasher := password.DefaultArgon2id
+
+		for name, target := range map[string]*uint32{"ARGON2_TIME": &hasher.Time, "ARGON2_MEMORY_KIB": &hasher.Memory} {
+			if value := os.Getenv(name); value != "" {
+				parsed, err := strconv.ParseUint(value, 10, 32)
+				if err != nil || parsed == 0 {
+					return nil, fmt.Errorf("invalid %s %q", name, value)
+				}
+				*target = uint32(parsed)
+			}
+		}
+
+		if value := os.Getenv("ARGON2_THREADS"); value != "" {
+			threads, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || threads == 0 {
+				return nil, fmt.Errorf("invalid ARGON2_THREADS %q", value)
+			}
+			hasher.Threads = uint8(threads)
+		}
+
+		return hasher, nil
+
+	case "bcrypt":
+		hasher := password.Bcrypt{Cost: bcrypt.DefaultCost}
+
+		if value := os.Getenv("BCRYPT_COST"); value != "" {
+			cost, err := strconv.Atoi(value)
+			if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+				return nil, fmt.Errorf("invalid BCRYPT_COST %q", value)
+			}
+			hasher.Cost = cost
+		}
+
+		return hasher, nil
+
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER %q", algorithm)
+	}
+}
+
+// rehashPassword upgrades a stored hash after a successful login, while the
+// plaintext is at hand. Failing here must not fail the login.
+func (cfg *apiConfig) rehashPassword(db *database.DB, user *models.User, plain string) {
+	if !cfg.passwordHasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	_, err := db.UpdateUser(user.Id, func(stored *models.User) error {
+		if stored.Password != user.Password {
+			return nil
+		}
+		return stored.SetPassword(plain, cfg.passwordHasher)
+	})
+	if err != nil {
+		fmt.Printf("Error rehashing password: %v\n", err)
+	}
+}
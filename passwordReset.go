@@ -0,0 +1,108 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/mailer"
+	"Chirpy/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const passwordResetTTL = 30 * time.Minute
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// forgotPassword answers the same way whether or not the email belongs to an
+// account, so it can't be used to find out who is registered. The mail is
+// sent in the background, so it doesn't show in the response time either.
+func (cfg *apiConfig) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var request forgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUserByEmail(request.Email)
+	if err == nil && user.PurgeStage == "" {
+		go func() {
+			err := cfg.sendPasswordReset(db, user.Id, user.Email)
+			if err != nil {
+				fmt.Printf("Error sending password reset: %v\n", err)
+			}
+		}()
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var request resetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	// The policy needs the user's email and handle, which the token leads to.
+	var policyErr error
+	user, err := db.ResetPassword(hashToken(request.Token), request.Password, cfg.passwordHasher, func(user *models.User) error {
+		policyErr = cfg.passwordPolicy.Check(request.Password, user.Email, user.Handle)
+		return policyErr
+	})
+	if policyErr != nil {
+		respondWithError(w, http.StatusBadRequest, policyErr.Error())
+		return
+	}
+	if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrExpired) {
+		respondWithError(w, http.StatusBadRequest, "Reset token is invalid or has expired")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error writing database")
+		return
+	}
+
+	cfg.revokeTokensOfUser(db, user.Id)
+	cfg.loginGuard.emails.reset(user.Email)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) sendPasswordReset(db *database.DB, userID int, email string) error {
+	token, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+
+	err = db.CreatePasswordReset(userID, hashToken(token), time.Now().Add(passwordResetTTL).UTC())
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Reset your Chirpy password",
+		Body: "Someone asked to reset the password of your Chirpy account. If it was you, send this token with your new password to " +
+			cfg.baseURL + "/api/password/reset within 30 minutes:\n\n" + token +
+			"\n\nIf it wasn't you, you can ignore this email.",
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResetPasswordChecksPolicyAgainstUser(t *testing.T) {
+	cfg := newTestConfig(t, "http://chirpy.test")
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := createTestUser(t, "walt@example.com")
+	_, err = db.UpdateUser(user.Id, func(user *models.User) error {
+		user.Handle = "walterwhite1958"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := "reset-token"
+	err = db.CreatePasswordReset(user.Id, hashToken(token), time.Now().Add(passwordResetTTL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reset := func(password string) int {
+		recorder := httptest.NewRecorder()
+		cfg.resetPassword(recorder, httptest.NewRequest(http.MethodPost, "/api/password/reset", strings.NewReader(
+			`{"token": "`+token+`", "password": "`+password+`"}`,
+		)))
+		return recorder.Code
+	}
+
+	if status := reset("walterwhite1958"); status != http.StatusBadRequest {
+		t.Fatalf("resetting to the handle: got status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// The rejected attempt didn't spend the token.
+	if status := reset("Another-Horse-77"); status != http.StatusNoContent {
+		t.Fatalf("resetting to a good password: got status %d, want %d", status, http.StatusNoContent)
+	}
+}
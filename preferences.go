@@ -0,0 +1,80 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func (cfg *apiConfig) getPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, withDefaultPreferences(user.Preferences))
+}
+
+func (cfg *apiConfig) updatePreferences(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	var preferences models.Preferences
+	if err := json.NewDecoder(r.Body).Decode(&preferences); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding preferences")
+		return
+	}
+
+	switch preferences.SensitiveContent {
+	case "", models.SensitiveContentWarn, models.SensitiveContentExpand, models.SensitiveContentHide:
+	default:
+		respondWithError(w, http.StatusBadRequest, "sensitive_content must be one of warn, expand or hide")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.UpdateUser(userID, func(user *models.User) error {
+		user.Preferences = preferences
+		return nil
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error writing database")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, withDefaultPreferences(user.Preferences))
+}
+
+func withDefaultPreferences(preferences models.Preferences) models.Preferences {
+	if preferences.SensitiveContent == "" {
+		preferences.SensitiveContent = models.SensitiveContentWarn
+	}
+
+	return preferences
+}
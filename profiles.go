@@ -0,0 +1,191 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/media"
+	"Chirpy/models"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+const maxAvatarBytes = 2 << 20
+
+func (cfg *apiConfig) getProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUser(userID)
+	if err != nil || user.Deleted() {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	respondWithProfile(w, db, user)
+}
+
+func (cfg *apiConfig) getProfileByHandle(w http.ResponseWriter, r *http.Request) {
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUserByHandle(r.PathValue("handle"))
+	if err != nil || user.Deleted() {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	respondWithProfile(w, db, user)
+}
+
+func (cfg *apiConfig) setFollow(following bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, targetID, db, ok := relationTarget(w, r)
+		if !ok {
+			return
+		}
+
+		var err error
+		if following {
+			err = db.Follow(userID, targetID)
+		} else {
+			err = db.Unfollow(userID, targetID)
+		}
+
+		if errors.Is(err, database.ErrBlocked) {
+			respondWithError(w, http.StatusForbidden, "You can't follow this user")
+			return
+		}
+
+		respondWithRelationResult(w, err)
+	}
+}
+
+func (cfg *apiConfig) uploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAvatarBytes))
+	if err != nil {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Avatar must be at most 2 MB")
+		return
+	}
+
+	name, err := cfg.media.SaveImage(data)
+	if errors.Is(err, media.ErrUnsupportedType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Avatar must be a PNG, JPEG, GIF or WebP image")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving avatar")
+		return
+	}
+
+	cfg.replaceAvatar(w, r, userID, name)
+}
+
+func (cfg *apiConfig) deleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	cfg.replaceAvatar(w, r, userID, "")
+}
+
+func (cfg *apiConfig) replaceAvatar(w http.ResponseWriter, r *http.Request, userID int, name string) {
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	previous := ""
+	user, err := db.UpdateUser(userID, func(user *models.User) error {
+		previous = user.AvatarFile
+		user.AvatarFile = name
+		return nil
+	})
+	if err != nil {
+		_ = cfg.media.Delete(name)
+		respondWithError(w, http.StatusInternalServerError, "Error writing database")
+		return
+	}
+
+	err = cfg.media.Delete(previous)
+	if err != nil {
+		fmt.Printf("Error deleting previous avatar: %v\n", err)
+	}
+
+	respondWithProfile(w, db, user)
+}
+
+func (cfg *apiConfig) serveMedia(w http.ResponseWriter, r *http.Request) {
+	path, err := cfg.media.Path(r.PathValue("name"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, path)
+}
+
+// respondWithProfile writes the public view of a user. It must never include
+// the email, password hash or tokens.
+func respondWithProfile(w http.ResponseWriter, db *database.DB, user *models.User) {
+	stats, err := db.GetProfileStats(user.Id)
+	if err != nil {
+		fmt.Printf("Error counting profile stats: %v\n", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, models.ProfileResponse{
+		Id:             user.Id,
+		Handle:         user.Handle,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		AvatarURL:      media.URL(user.AvatarFile),
+		FollowerCount:  stats.FollowerCount,
+		FollowingCount: stats.FollowingCount,
+		ChirpCount:     stats.ChirpCount,
+	})
+}
+
+func validateProfileFields(db *database.DB, userID int, update models.UserUpdate) (int, error) {
+	if update.Handle != nil {
+		if err := models.ValidateHandle(*update.Handle); err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		if !db.HandleAvailable(*update.Handle, userID) {
+			return http.StatusConflict, errors.New("handle is already taken")
+		}
+	}
+
+	if update.DisplayName != nil {
+		if err := models.ValidateDisplayName(*update.DisplayName); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	if update.Bio != nil {
+		if err := models.ValidateBio(*update.Bio); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	return http.StatusOK, nil
+}
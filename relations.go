@@ -0,0 +1,138 @@
+package main
+
+import (
+	"Chirpy/database"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+type relationResponse struct {
+	UserIDs []int `json:"user_ids"`
+}
+
+func (cfg *apiConfig) setBlock(blocked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, targetID, db, ok := relationTarget(w, r)
+		if !ok {
+			return
+		}
+
+		var err error
+		if blocked {
+			err = db.Block(userID, targetID)
+		} else {
+			err = db.Unblock(userID, targetID)
+		}
+
+		respondWithRelationResult(w, err)
+	}
+}
+
+func (cfg *apiConfig) setMute(muted bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, targetID, db, ok := relationTarget(w, r)
+		if !ok {
+			return
+		}
+
+		var err error
+		if muted {
+			err = db.Mute(userID, targetID)
+		} else {
+			err = db.Unmute(userID, targetID)
+		}
+
+		respondWithRelationResult(w, err)
+	}
+}
+
+func (cfg *apiConfig) listBlocked(w http.ResponseWriter, r *http.Request) {
+	cfg.listRelation(w, r, (*database.DB).GetBlocked)
+}
+
+func (cfg *apiConfig) listMuted(w http.ResponseWriter, r *http.Request) {
+	cfg.listRelation(w, r, (*database.DB).GetMuted)
+}
+
+func (cfg *apiConfig) listRelation(w http.ResponseWriter, r *http.Request, load func(db *database.DB, userID int) ([]int, error)) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	userIDs, err := load(db, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error loading database")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, relationResponse{UserIDs: userIDs})
+}
+
+func relationTarget(w http.ResponseWriter, r *http.Request) (int, int, *database.DB, bool) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return 0, 0, nil, false
+	}
+
+	targetID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return 0, 0, nil, false
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	return userID, targetID, db, true
+}
+
+func respondWithRelationResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, database.ErrSelfRelation):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, database.ErrNotFound):
+		respondWithError(w, http.StatusNotFound, "user not found")
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Error writing database")
+	}
+}
+
+var mentionPattern = regexp.MustCompile(`@([A-Za-z0-9_]{3,15})\b`)
+
+// blockedMention returns the first handle mentioned in body whose owner and
+// the author have blocked one another.
+func blockedMention(db *database.DB, authorID int, body string) (string, bool) {
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		mentioned, err := db.GetUserByHandle(match[1])
+		if err != nil {
+			continue
+		}
+
+		blocked, err := db.IsBlockedEitherWay(authorID, mentioned.Id)
+		if err != nil {
+			fmt.Printf("Error checking blocks: %v\n", err)
+			continue
+		}
+
+		if blocked {
+			return match[1], true
+		}
+	}
+
+	return "", false
+}
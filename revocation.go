@@ -0,0 +1,141 @@
+package main
+
+import (
+	"Chirpy/database"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenDenylist answers whether an access token was revoked without touching
+// the database, since it is asked on every authenticated request. It is
+// loaded at start and written through on every revocation.
+type tokenDenylist struct {
+	mu            sync.RWMutex
+	jtis          map[string]time.Time
+	revokedBefore map[int]time.Time
+}
+
+func loadTokenDenylist(db *database.DB) (*tokenDenylist, error) {
+	jtis, revokedBefore, err := db.GetRevocations()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenDenylist{jtis: jtis, revokedBefore: revokedBefore}, nil
+}
+
+func (d *tokenDenylist) revoked(claims *accessClaims) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if _, ok := d.jtis[claims.ID]; claims.ID != "" && ok {
+		return true
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return true
+	}
+
+	before, ok := d.revokedBefore[userID]
+	if !ok {
+		return false
+	}
+
+	return claims.IssuedAt == nil || claims.IssuedAt.Time.Before(before)
+}
+
+// deny adds a jti and drops the entries whose tokens have expired by now.
+func (d *tokenDenylist) deny(jti string, expiresAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for id, tokenExpiresAt := range d.jtis {
+		if !now.Before(tokenExpiresAt) {
+			delete(d.jtis, id)
+		}
+	}
+
+	d.jtis[jti] = expiresAt
+}
+
+func (d *tokenDenylist) revokeBefore(userID int, before time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.revokedBefore[userID] = before
+}
+
+// denyAccessToken revokes a single access token until it expires.
+func (cfg *apiConfig) denyAccessToken(db *database.DB, claims *accessClaims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	err := db.DenyToken(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return err
+	}
+
+	cfg.denylist.deny(claims.ID, claims.ExpiresAt.Time)
+	return nil
+}
+
+// revokeTokensOfUser revokes every access token the user has now. The cut is
+// as precise as the token times, so a token issued right after it still works.
+func (cfg *apiConfig) revokeTokensOfUser(db *database.DB, userID int) {
+	before := time.Now().UTC().Truncate(jwt.TimePrecision)
+
+	err := db.RevokeTokensOfUser(userID, before)
+	if err != nil {
+		fmt.Printf("Error revoking tokens of user %d: %v\n", userID, err)
+		return
+	}
+
+	cfg.denylist.revokeBefore(userID, before)
+}
+
+func (cfg *apiConfig) denyAccessCookie(r *http.Request, db *database.DB) {
+	cookie, err := r.Cookie(accessCookie)
+	if err != nil {
+		return
+	}
+
+	claims, err := cfg.parseJWTToken(cookie.Value)
+	if err != nil {
+		return
+	}
+
+	err = cfg.denyAccessToken(db, claims)
+	if err != nil {
+		fmt.Printf("Error revoking access token: %v\n", err)
+	}
+}
+
+// logout revokes the access token the request was made with.
+func (cfg *apiConfig) logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromRequest(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	err = cfg.denyAccessToken(db, claims)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking token")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"Chirpy/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	scopeChirpsWrite  = "chirps:write"
+	scopeChirpsDelete = "chirps:delete"
+	scopeProfileWrite = "profile:write"
+	scopeAccount      = "account"
+	scopeAdmin        = "admin"
+)
+
+// userScopes are granted to every user; scopeAdmin only to moderators and
+// admins.
+var userScopes = []string{scopeChirpsWrite, scopeChirpsDelete, scopeProfileWrite, scopeAccount}
+
+var errInvalidScope = errors.New("invalid scope")
+
+// accessClaims are the claims of an access token. Scope is the space
+// separated list of scopes, as in OAuth 2.0.
+type accessClaims struct {
+	Scope string `json:"scope,omitempty"`
+	// ClientID names the third-party app a token was issued to (RFC 9068).
+	ClientID string `json:"client_id,omitempty"`
+	Role     string `json:"role,omitempty"`
+	// CSRFHash binds a cookie mode token to its CSRF token.
+	CSRFHash string `json:"csrf,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (c *accessClaims) hasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+func allowedScopes(user *models.User) []string {
+	scopes := slices.Clone(userScopes)
+	if user.HasRole(models.RoleModerator) {
+		scopes = append(scopes, scopeAdmin)
+	}
+
+	return scopes
+}
+
+// grantScopes narrows a login to the requested scopes, e.g. for a third
+// party client. No request means every scope the user is allowed. Scopes the
+// user isn't allowed are left out; unknown ones, or nothing left to grant, are
+// an error.
+func grantScopes(user *models.User, requested string) (string, error) {
+	allowed := allowedScopes(user)
+
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(allowed, " "), nil
+	}
+
+	granted := []string{}
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(userScopes, scope) && scope != scopeAdmin {
+			return "", fmt.Errorf("%w %q", errInvalidScope, scope)
+		}
+		if slices.Contains(allowed, scope) && !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	if len(granted) == 0 {
+		return "", fmt.Errorf("%w: none of the requested scopes can be granted", errInvalidScope)
+	}
+
+	return strings.Join(granted, " "), nil
+}
+
+// grantDelegatedScopes works like grantScopes for API keys and third-party
+// apps, but never hands out account: whoever holds their credentials mustn't
+// be able to change the password or hand out further access.
+func grantDelegatedScopes(user *models.User, requested string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		requested = strings.Join(slices.DeleteFunc(allowedScopes(user), func(scope string) bool {
+			return scope == scopeAccount
+		}), " ")
+	}
+
+	if slices.Contains(strings.Fields(requested), scopeAccount) {
+		return "", fmt.Errorf("%w: the %s scope can't be delegated", errInvalidScope, scopeAccount)
+	}
+
+	return grantScopes(user, requested)
+}
+
+// requireScope lets the request through only when its access token carries
+// scope. A missing scope is answered as RFC 6750 describes.
+func (cfg *apiConfig) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.checkJWTToken(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromRequest(r)
+		if !ok || !claims.hasScope(scope) {
+			respondWithInsufficientScope(w, scope)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func respondWithInsufficientScope(w http.ResponseWriter, scope string) {
+	description := fmt.Sprintf("The access token lacks the %s scope", scope)
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s", error_description="%s"`, scope, description))
+	respondWithError(w, http.StatusForbidden, description)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/keyring"
+	"Chirpy/mailer"
+	"Chirpy/models"
+	"Chirpy/oidc"
+	"Chirpy/password"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testPassword = "Correct-Horse-42"
+
+// testHasher is argon2id with the cheapest parameters, to keep tests fast.
+var testHasher = password.Argon2id{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+
+// newTestConfig sets up a server in a fresh working directory, where the
+// handlers open database.json. Tests using it can't run in parallel.
+func newTestConfig(t *testing.T, baseURL string) *apiConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(previous)
+	})
+
+	jwt.TimePrecision = time.Millisecond
+
+	keys, err := keyring.Load(filepath.Join(dir, "keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	denylist, err := loadTokenDenylist(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dummyHash, err := testHasher.Hash("not-a-real-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &apiConfig{
+		keyring:        keys,
+		mailer:         mailer.NewMemoryMailer(),
+		baseURL:        baseURL,
+		passwordPolicy: password.Policy{MinLength: 8, MinScore: 2},
+		passwordHasher: testHasher,
+		loginGuard:     newLoginGuard(dummyHash),
+		oidcProviders:  map[string]*oidc.Provider{},
+		oidcLogins:     newOIDCLogins(),
+		denylist:       denylist,
+		tokens: tokenConfig{
+			Audience:   baseURL,
+			DefaultTTL: time.Hour,
+			MinTTL:     time.Minute,
+			MaxTTL:     24 * time.Hour,
+		},
+	}
+}
+
+// createTestUser registers a user with a verified email and testPassword.
+func createTestUser(t *testing.T, email string) *models.User {
+	t.Helper()
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadDB, err := db.LoadDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := fmt.Sprintf(`{"email": %q, "password": %q}`, email, testPassword)
+	item, _, err := db.CreateUser(body, testHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.WriteDB(loadDB, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.UpdateUser(item.GetId(), func(user *models.User) error {
+		user.EmailVerified = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// sendRequest sends body, a url.Values as a form or anything else as JSON,
+// and decodes the JSON response into response when it isn't nil.
+func sendRequest(t *testing.T, method string, target string, token string, body any, response any) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	contentType := ""
+	switch body := body.(type) {
+	case nil:
+	case url.Values:
+		reader = strings.NewReader(body.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = strings.NewReader(string(data))
+		contentType = "application/json"
+	}
+
+	request, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	// Redirects are part of what the OIDC tests look at.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if response != nil {
+		err = json.NewDecoder(resp.Body).Decode(response)
+		if err != nil {
+			t.Fatalf("%s %s: decoding response with status %d: %v", method, target, resp.StatusCode, err)
+		}
+	}
+
+	return resp
+}
+
+func loginTestUser(t *testing.T, serverURL string, email string) models.APIUserResponse {
+	t.Helper()
+
+	var response models.APIUserResponse
+	resp := sendRequest(t, http.MethodPost, serverURL+"/api/login", "", loginRequest{Email: email, Password: testPassword}, &response)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login: status %d", resp.StatusCode)
+	}
+
+	return response
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxDeviceLength    = 64
+	maxUserAgentLength = 256
+
+	// refreshTokenTTL slides: every refresh issues a token valid this long.
+	refreshTokenTTL = 60 * 24 * time.Hour
+)
+
+type sessionsResponse struct {
+	Sessions []models.SessionResponse `json:"sessions"`
+}
+
+// startSession opens a new session for a login and returns its refresh
+// token. Only the hash of the token is stored.
+func (cfg *apiConfig) startSession(r *http.Request, db *database.DB, userID int, device string, scope string) (string, error) {
+	token, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.CreateSession(models.Session{
+		UserId:    userID,
+		Device:    truncate(strings.TrimSpace(device), maxDeviceLength),
+		IP:        clientIP(r),
+		UserAgent: truncate(r.UserAgent(), maxUserAgentLength),
+		Scope:     scope,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+	}, hashToken(token))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// refresh rotates the refresh token: the presented one stops working and a
+// new one comes back with the access token. In cookie mode both come back as
+// cookies, together with a new CSRF token.
+func (cfg *apiConfig) refresh(w http.ResponseWriter, r *http.Request) {
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	refreshToken, cookie, err := refreshTokenFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	newRefreshToken, err := newSecretToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating refresh token")
+		return
+	}
+
+	session, err := db.RotateRefreshToken(
+		hashToken(refreshToken),
+		"",
+		hashToken(newRefreshToken),
+		time.Now().UTC().Add(refreshTokenTTL),
+		clientIP(r),
+		truncate(r.UserAgent(), maxUserAgentLength),
+	)
+	if errors.Is(err, database.ErrTokenReused) {
+		fmt.Printf("Refresh token reused from %s, session revoked\n", clientIP(r))
+		respondWithError(w, http.StatusUnauthorized, "Refresh token was already used, the session has been revoked")
+		return
+	}
+	if errors.Is(err, database.ErrExpired) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has expired")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Wrong refresh token")
+		return
+	}
+
+	user, err := db.GetUser(session.UserId)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Wrong refresh token")
+		return
+	}
+
+	if user.Suspended {
+		respondWithError(w, http.StatusForbidden, "This account is suspended")
+		return
+	}
+
+	// The session keeps the scopes of its login, minus any the user has
+	// lost since, such as admin after a demotion.
+	scope, err := grantScopes(user, session.Scope)
+	if err != nil {
+		scope = ""
+	}
+
+	claims := &accessClaims{Scope: scope}
+
+	csrfToken := ""
+	if cookie {
+		csrfToken, err = newSecretToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error creating CSRF token")
+			return
+		}
+		claims.CSRFHash = hashToken(csrfToken)
+	}
+
+	tokenString, err := cfg.issueAccessToken(user, claims)
+	if err != nil {
+		fmt.Println("Error signing token:", err)
+		respondWithError(w, http.StatusInternalServerError, "Error signing token")
+		return
+	}
+
+	if cookie {
+		setSessionCookies(w, tokenString, claims.ExpiresAt.Time, newRefreshToken, csrfToken)
+		respondWithJSON(w, http.StatusOK, models.TokenResponse{
+			Scope:     scope,
+			CSRFToken: csrfToken,
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, models.TokenResponse{
+		Token:        tokenString,
+		RefreshToken: newRefreshToken,
+		Scope:        scope,
+	})
+}
+
+// revoke logs out the session the refresh token belongs to. Other devices
+// stay logged in. In cookie mode the cookies are cleared and the access token
+// is revoked too.
+func (cfg *apiConfig) revoke(w http.ResponseWriter, r *http.Request) {
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error loading DB: %v\n", err)
+	}
+
+	refreshToken, cookie, err := refreshTokenFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	err = db.RevokeSessionByToken(hashToken(refreshToken), "")
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "There is not yours token")
+		return
+	}
+
+	// The access cookie goes away with the others, but a copy of it would
+	// keep working until it expires.
+	if cookie {
+		cfg.denyAccessCookie(r, db)
+		clearSessionCookies(w)
+	}
+
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) listSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	sessions, err := db.GetSessionsOfUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error loading sessions")
+		return
+	}
+
+	response := sessionsResponse{Sessions: make([]models.SessionResponse, 0, len(sessions))}
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, session.Response())
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) revokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	sessionID, err := strconv.Atoi(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid sessionID")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	err = db.RevokeSession(userID, sessionID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking session")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func truncate(value string, maxLength int) string {
+	if len(value) <= maxLength {
+		return value
+	}
+
+	// Back off to a rune boundary so the result stays valid UTF-8.
+	for maxLength > 0 && value[maxLength]&0xC0 == 0x80 {
+		maxLength--
+	}
+
+	return value[:maxLength]
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"Chirpy/keyring"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksMaxAge is how long verifiers may cache our key set, and so how long a
+// new key has to be published before it can sign.
+const jwksMaxAge = 5 * time.Minute
+
+// signAccessToken signs with the active key and names it in the kid header,
+// so verifiers can pick the right public key after a rotation.
+func (cfg *apiConfig) signAccessToken(claims jwt.Claims) (string, error) {
+	kid, key := cfg.keyring.Signer()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = kid
+
+	return token.SignedString(key)
+}
+
+func (cfg *apiConfig) verificationKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodEd25519); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token has no key id")
+	}
+
+	return cfg.keyring.PublicKey(kid)
+}
+
+// serveJWKS publishes the public keys for services that verify our tokens.
+// Unlike API responses it may be cached for a little while.
+func (cfg *apiConfig) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(cfg.keyring.JWKS())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error encoding keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(data)
+	if err != nil {
+		fmt.Printf("Error writing response: %v\n", err)
+	}
+}
+
+func reloadKeysOnSignal(keys *keyring.Keyring) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		err := keys.Reload()
+		if err != nil {
+			fmt.Printf("Error reloading signing keys: %v\n", err)
+			continue
+		}
+
+		kid, _ := keys.Signer()
+		fmt.Printf("Signing keys reloaded, active key %s\n", kid)
+	}
+}
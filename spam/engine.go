@@ -0,0 +1,66 @@
+package spam
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// Engine holds the rules loaded from a JSON config file. Reload swaps them in
+// place, so a running server picks up edits without a restart.
+type Engine struct {
+	path  string
+	mux   sync.RWMutex
+	rules Rules
+}
+
+func NewEngine(path string) (*Engine, error) {
+	engine := &Engine{
+		path:  path,
+		rules: DefaultRules(),
+	}
+
+	err := engine.Reload()
+	if errors.Is(err, os.ErrNotExist) {
+		return engine, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+func (e *Engine) Reload() error {
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+
+	rules := DefaultRules()
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+
+	e.mux.Lock()
+	e.rules = rules
+	e.mux.Unlock()
+
+	return nil
+}
+
+func (e *Engine) Rules() Rules {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+
+	return e.rules
+}
+
+func (e *Engine) Evaluate(input Input) Verdict {
+	return e.Rules().Evaluate(input)
+}
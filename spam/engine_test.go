@@ -0,0 +1,94 @@
+package spam
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRules(t *testing.T, path string, data string) {
+	t.Helper()
+
+	err := os.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewEngineWithoutFile(t *testing.T) {
+	engine, err := NewEngine(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	if engine.Rules().HoldThreshold != DefaultRules().HoldThreshold {
+		t.Errorf("rules = %+v, want the defaults", engine.Rules())
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	writeRules(t, path, `{"hold_threshold": 2, "reject_threshold": 3}`)
+
+	engine, err := NewEngine(path)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	rules := engine.Rules()
+	if rules.HoldThreshold != 2 || rules.RejectThreshold != 3 {
+		t.Errorf("thresholds = %v, %v, want 2, 3", rules.HoldThreshold, rules.RejectThreshold)
+	}
+	// Settings missing from the file keep their defaults.
+	if rules.LinkCount != DefaultRules().LinkCount {
+		t.Errorf("link_count = %+v, want the default", rules.LinkCount)
+	}
+
+	writeRules(t, path, `{"hold_threshold": 4, "reject_threshold": 8, "blocked_domains": {"domains": ["spam.example"], "score": 8}}`)
+	if err := engine.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	verdict := engine.Evaluate(Input{Body: "http://spam.example", Now: now})
+	if verdict.Action != ActionReject {
+		t.Errorf("action after reload = %q, want %q", verdict.Action, ActionReject)
+	}
+}
+
+func TestEngineReloadKeepsRulesOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	writeRules(t, path, `{"hold_threshold": 2, "reject_threshold": 3}`)
+
+	engine, err := NewEngine(path)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	for name, data := range map[string]string{
+		"invalid json":      `{"hold_threshold": `,
+		"invalid threshold": `{"hold_threshold": 9, "reject_threshold": 3}`,
+	} {
+		writeRules(t, path, data)
+
+		if err := engine.Reload(); err == nil {
+			t.Errorf("%s: Reload() succeeded", name)
+		}
+		if engine.Rules().HoldThreshold != 2 {
+			t.Errorf("%s: rules were replaced", name)
+		}
+	}
+
+	os.Remove(path)
+	if err := engine.Reload(); err == nil {
+		t.Error("Reload() of a missing file succeeded")
+	}
+}
+
+func TestNewEngineInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	writeRules(t, path, `{"hold_threshold": -1}`)
+
+	if _, err := NewEngine(path); err == nil {
+		t.Error("NewEngine() accepted invalid rules")
+	}
+}
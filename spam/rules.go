@@ -0,0 +1,186 @@
+package spam
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ActionAllow  = "allow"
+	ActionHold   = "hold"
+	ActionReject = "reject"
+)
+
+type Rules struct {
+	HoldThreshold   float64            `json:"hold_threshold"`
+	RejectThreshold float64            `json:"reject_threshold"`
+	LinkCount       LinkCountRule      `json:"link_count"`
+	DuplicateBody   DuplicateBodyRule  `json:"duplicate_body"`
+	PostingRate     PostingRateRule    `json:"posting_rate"`
+	NewAccount      NewAccountRule     `json:"new_account"`
+	BlockedDomains  BlockedDomainsRule `json:"blocked_domains"`
+}
+
+type LinkCountRule struct {
+	Max   int     `json:"max"`
+	Score float64 `json:"score"`
+}
+
+type DuplicateBodyRule struct {
+	WindowSeconds int     `json:"window_seconds"`
+	Score         float64 `json:"score"`
+}
+
+type PostingRateRule struct {
+	MaxChirps     int     `json:"max_chirps"`
+	WindowSeconds int     `json:"window_seconds"`
+	Score         float64 `json:"score"`
+}
+
+type NewAccountRule struct {
+	MinAgeSeconds int     `json:"min_age_seconds"`
+	Score         float64 `json:"score"`
+}
+
+type BlockedDomainsRule struct {
+	Domains []string `json:"domains"`
+	Score   float64  `json:"score"`
+}
+
+// Post is an earlier chirp of the same author, in any order.
+type Post struct {
+	Body      string
+	CreatedAt time.Time
+}
+
+type Input struct {
+	Body             string
+	AccountCreatedAt time.Time
+	RecentPosts      []Post
+	Now              time.Time
+}
+
+type Verdict struct {
+	Score   float64  `json:"score"`
+	Action  string   `json:"action"`
+	Reasons []string `json:"reasons"`
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s]+`)
+
+func DefaultRules() Rules {
+	return Rules{
+		HoldThreshold:   5,
+		RejectThreshold: 10,
+		LinkCount:       LinkCountRule{Max: 2, Score: 3},
+		DuplicateBody:   DuplicateBodyRule{WindowSeconds: 3600, Score: 4},
+		PostingRate:     PostingRateRule{MaxChirps: 5, WindowSeconds: 60, Score: 4},
+		NewAccount:      NewAccountRule{MinAgeSeconds: 86400, Score: 2},
+		BlockedDomains:  BlockedDomainsRule{Score: 10},
+	}
+}
+
+func (r Rules) Validate() error {
+	if r.HoldThreshold <= 0 || r.RejectThreshold <= 0 {
+		return fmt.Errorf("thresholds must be positive")
+	}
+
+	if r.RejectThreshold < r.HoldThreshold {
+		return fmt.Errorf("reject_threshold must not be lower than hold_threshold")
+	}
+
+	return nil
+}
+
+func (r Rules) Evaluate(input Input) Verdict {
+	verdict := Verdict{Action: ActionAllow, Reasons: []string{}}
+	links := linkPattern.FindAllString(input.Body, -1)
+
+	if r.LinkCount.Max > 0 && len(links) > r.LinkCount.Max {
+		verdict.add(r.LinkCount.Score, fmt.Sprintf("%d links, more than %d allowed", len(links), r.LinkCount.Max))
+	}
+
+	if r.DuplicateBody.WindowSeconds > 0 {
+		since := input.Now.Add(-time.Duration(r.DuplicateBody.WindowSeconds) * time.Second)
+		body := normalizeBody(input.Body)
+
+		for _, post := range input.RecentPosts {
+			if post.CreatedAt.After(since) && normalizeBody(post.Body) == body {
+				verdict.add(r.DuplicateBody.Score, "same body was posted recently")
+				break
+			}
+		}
+	}
+
+	if r.PostingRate.MaxChirps > 0 && r.PostingRate.WindowSeconds > 0 {
+		since := input.Now.Add(-time.Duration(r.PostingRate.WindowSeconds) * time.Second)
+		recent := 0
+
+		for _, post := range input.RecentPosts {
+			if post.CreatedAt.After(since) {
+				recent++
+			}
+		}
+
+		if recent >= r.PostingRate.MaxChirps {
+			verdict.add(r.PostingRate.Score, fmt.Sprintf("%d chirps in the last %d seconds", recent, r.PostingRate.WindowSeconds))
+		}
+	}
+
+	if r.NewAccount.MinAgeSeconds > 0 && !input.AccountCreatedAt.IsZero() {
+		age := input.Now.Sub(input.AccountCreatedAt)
+
+		if age < time.Duration(r.NewAccount.MinAgeSeconds)*time.Second {
+			verdict.add(r.NewAccount.Score, "account is new")
+		}
+	}
+
+	for _, link := range links {
+		if domain, ok := r.BlockedDomains.match(link); ok {
+			verdict.add(r.BlockedDomains.Score, "links to blocked domain "+domain)
+		}
+	}
+
+	switch {
+	case verdict.Score >= r.RejectThreshold:
+		verdict.Action = ActionReject
+	case verdict.Score >= r.HoldThreshold:
+		verdict.Action = ActionHold
+	}
+
+	return verdict
+}
+
+func (v *Verdict) add(score float64, reason string) {
+	v.Score += score
+	v.Reasons = append(v.Reasons, reason)
+}
+
+func (b BlockedDomainsRule) match(link string) (string, bool) {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return "", false
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, domain := range b.Domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return domain, true
+		}
+	}
+
+	return "", false
+}
+
+func normalizeBody(body string) string {
+	return strings.Join(strings.Fields(strings.ToLower(body)), " ")
+}
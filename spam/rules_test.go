@@ -0,0 +1,225 @@
+package spam
+
+import (
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 8, 30, 12, 0, 0, 0, time.UTC)
+
+// oldAccount keeps the new account rule out of tests about other rules.
+var oldAccount = now.Add(-365 * 24 * time.Hour)
+
+func posts(body string, count int, age time.Duration) []Post {
+	result := make([]Post, count)
+	for i := range result {
+		result[i] = Post{Body: body, CreatedAt: now.Add(-age)}
+	}
+	return result
+}
+
+func TestRulesEvaluate(t *testing.T) {
+	rules := DefaultRules()
+	rules.BlockedDomains.Domains = []string{"spam.example", ".Casino.test"}
+
+	tests := []struct {
+		name    string
+		input   Input
+		score   float64
+		action  string
+		reasons int
+	}{
+		{
+			name:   "clean chirp",
+			input:  Input{Body: "Hello world", AccountCreatedAt: oldAccount},
+			action: ActionAllow,
+		},
+		{
+			name:    "links up to the limit",
+			input:   Input{Body: "https://a.example www.b.example", AccountCreatedAt: oldAccount},
+			action:  ActionAllow,
+			reasons: 0,
+		},
+		{
+			name:    "too many links",
+			input:   Input{Body: "https://a.example http://b.example www.c.example", AccountCreatedAt: oldAccount},
+			score:   3,
+			action:  ActionAllow,
+			reasons: 1,
+		},
+		{
+			name: "duplicate within the window",
+			input: Input{
+				Body:             "Buy  NOW",
+				AccountCreatedAt: oldAccount,
+				RecentPosts:      posts("buy now", 1, 30*time.Minute),
+			},
+			score:   4,
+			action:  ActionAllow,
+			reasons: 1,
+		},
+		{
+			name: "duplicate outside the window",
+			input: Input{
+				Body:             "buy now",
+				AccountCreatedAt: oldAccount,
+				RecentPosts:      posts("buy now", 1, 2*time.Hour),
+			},
+			action: ActionAllow,
+		},
+		{
+			name: "posting rate reached",
+			input: Input{
+				Body:             "another one",
+				AccountCreatedAt: oldAccount,
+				RecentPosts:      posts("something", 5, 10*time.Second),
+			},
+			score:   4,
+			action:  ActionAllow,
+			reasons: 1,
+		},
+		{
+			name: "posting rate below the limit",
+			input: Input{
+				Body:             "another one",
+				AccountCreatedAt: oldAccount,
+				RecentPosts:      posts("something", 4, 10*time.Second),
+			},
+			action: ActionAllow,
+		},
+		{
+			name:    "new account",
+			input:   Input{Body: "hi", AccountCreatedAt: now.Add(-time.Hour)},
+			score:   2,
+			action:  ActionAllow,
+			reasons: 1,
+		},
+		{
+			name:   "unknown account age",
+			input:  Input{Body: "hi"},
+			action: ActionAllow,
+		},
+		{
+			name:    "blocked domain",
+			input:   Input{Body: "see http://spam.example/x", AccountCreatedAt: oldAccount},
+			score:   10,
+			action:  ActionReject,
+			reasons: 1,
+		},
+		{
+			name:    "blocked subdomain, any case",
+			input:   Input{Body: "www.WIN.casino.test", AccountCreatedAt: oldAccount},
+			score:   10,
+			action:  ActionReject,
+			reasons: 1,
+		},
+		{
+			name:   "lookalike domain is not blocked",
+			input:  Input{Body: "http://notspam.example", AccountCreatedAt: oldAccount},
+			action: ActionAllow,
+		},
+		{
+			name: "hold threshold reached",
+			input: Input{
+				Body:             "buy now",
+				AccountCreatedAt: now.Add(-time.Hour),
+				RecentPosts:      posts("buy now", 1, time.Minute),
+			},
+			score:   6,
+			action:  ActionHold,
+			reasons: 2,
+		},
+		{
+			name: "reject threshold reached",
+			input: Input{
+				Body:             "buy now https://a.example https://b.example https://c.example",
+				AccountCreatedAt: oldAccount,
+				RecentPosts:      posts("buy now https://a.example https://b.example https://c.example", 5, time.Second),
+			},
+			score:   11,
+			action:  ActionReject,
+			reasons: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.input.Now = now
+			verdict := rules.Evaluate(tt.input)
+
+			if verdict.Score != tt.score {
+				t.Errorf("score = %v, want %v (reasons %q)", verdict.Score, tt.score, verdict.Reasons)
+			}
+			if verdict.Action != tt.action {
+				t.Errorf("action = %q, want %q", verdict.Action, tt.action)
+			}
+			if len(verdict.Reasons) != tt.reasons {
+				t.Errorf("reasons = %q, want %d of them", verdict.Reasons, tt.reasons)
+			}
+		})
+	}
+}
+
+func TestRulesThresholds(t *testing.T) {
+	tests := []struct {
+		score  float64
+		action string
+	}{
+		{4.9, ActionAllow},
+		{5, ActionHold},
+		{9.9, ActionHold},
+		{10, ActionReject},
+	}
+
+	for _, tt := range tests {
+		rules := Rules{
+			HoldThreshold:   5,
+			RejectThreshold: 10,
+			NewAccount:      NewAccountRule{MinAgeSeconds: 60, Score: tt.score},
+		}
+
+		verdict := rules.Evaluate(Input{Body: "hi", AccountCreatedAt: now, Now: now})
+		if verdict.Action != tt.action {
+			t.Errorf("score %v: action = %q, want %q", tt.score, verdict.Action, tt.action)
+		}
+	}
+}
+
+func TestRulesDisabled(t *testing.T) {
+	rules := Rules{HoldThreshold: 1, RejectThreshold: 1}
+	input := Input{
+		Body:             "https://a.example https://b.example https://c.example",
+		AccountCreatedAt: now,
+		RecentPosts:      posts("https://a.example https://b.example https://c.example", 10, time.Second),
+		Now:              now,
+	}
+
+	verdict := rules.Evaluate(input)
+	if verdict.Score != 0 || verdict.Action != ActionAllow {
+		t.Errorf("rules with zero settings scored %v, %q", verdict.Score, verdict.Action)
+	}
+}
+
+func TestRulesValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		hold  float64
+		rej   float64
+		valid bool
+	}{
+		{"defaults", 5, 10, true},
+		{"equal thresholds", 5, 5, true},
+		{"zero hold", 0, 10, false},
+		{"negative reject", 5, -1, false},
+		{"reject below hold", 10, 5, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Rules{HoldThreshold: tt.hold, RejectThreshold: tt.rej}.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
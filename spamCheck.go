@@ -0,0 +1,49 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"Chirpy/spam"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func (cfg *apiConfig) checkSpam(db *database.DB, author *models.User, chirp *models.Chirp) spam.Verdict {
+	input := spam.Input{
+		Body:             chirp.Body,
+		AccountCreatedAt: author.CreatedAt,
+		Now:              time.Now(),
+	}
+
+	chirps, err := db.GetChirpsByAuthor(author.Id)
+	if err != nil {
+		fmt.Printf("Error loading chirps of author: %v\n", err)
+	}
+
+	for _, previous := range chirps {
+		input.RecentPosts = append(input.RecentPosts, spam.Post{
+			Body:      previous.Body,
+			CreatedAt: previous.CreatedAt,
+		})
+	}
+
+	return cfg.spam.Evaluate(input)
+}
+
+func reloadSpamRulesOnSignal(engine *spam.Engine) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		err := engine.Reload()
+		if err != nil {
+			fmt.Printf("Error reloading spam rules: %v\n", err)
+			continue
+		}
+
+		fmt.Println("Spam rules reloaded")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"Chirpy/models"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultAccessTokenTTL    = time.Hour
+	defaultAccessTokenMinTTL = 5 * time.Minute
+	defaultAccessTokenMaxTTL = 24 * time.Hour
+)
+
+// tokenConfig is what access tokens are issued with. A user may choose their
+// own lifetime between MinTTL and MaxTTL; everyone else gets DefaultTTL.
+type tokenConfig struct {
+	Audience   string
+	DefaultTTL time.Duration
+	MinTTL     time.Duration
+	MaxTTL     time.Duration
+}
+
+func tokenConfigFromEnv(baseURL string) (tokenConfig, error) {
+	config := tokenConfig{
+		Audience:   os.Getenv("TOKEN_AUDIENCE"),
+		DefaultTTL: defaultAccessTokenTTL,
+		MinTTL:     defaultAccessTokenMinTTL,
+		MaxTTL:     defaultAccessTokenMaxTTL,
+	}
+	if config.Audience == "" {
+		config.Audience = baseURL
+	}
+
+	for name, target := range map[string]*time.Duration{
+		"ACCESS_TOKEN_TTL":     &config.DefaultTTL,
+		"ACCESS_TOKEN_MIN_TTL": &config.MinTTL,
+		"ACCESS_TOKEN_MAX_TTL": &config.MaxTTL,
+	} {
+		if value := os.Getenv(name); value != "" {
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl < time.Second {
+				return config, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = ttl
+		}
+	}
+
+	if config.MinTTL > config.DefaultTTL || config.DefaultTTL > config.MaxTTL {
+		return config, fmt.Errorf("ACCESS_TOKEN_TTL %v must be between ACCESS_TOKEN_MIN_TTL %v and ACCESS_TOKEN_MAX_TTL %v", config.DefaultTTL, config.MinTTL, config.MaxTTL)
+	}
+
+	return config, nil
+}
+
+// checkUserTTL validates the lifetime a user asks for, in seconds. Zero goes
+// back to the default.
+func (c tokenConfig) checkUserTTL(seconds int) error {
+	ttl := time.Duration(seconds) * time.Second
+	if seconds != 0 && (ttl < c.MinTTL || ttl > c.MaxTTL) {
+		return fmt.Errorf("expires_in_seconds must be 0 or between %d and %d", int(c.MinTTL.Seconds()), int(c.MaxTTL.Seconds()))
+	}
+
+	return nil
+}
+
+// ttlFor returns how long the user's access tokens live. A setting chosen
+// under looser bounds is clamped to the current ones.
+func (c tokenConfig) ttlFor(user *models.User) time.Duration {
+	if user.ExpiresInSeconds <= 0 {
+		return c.DefaultTTL
+	}
+
+	ttl := time.Duration(user.ExpiresInSeconds) * time.Second
+	return min(max(ttl, c.MinTTL), c.MaxTTL)
+}
+
+// issueAccessToken signs an access token for user. Logins, refreshes and OAuth
+// grants all get their tokens here. claims brings the scope and, where they
+// apply, the client and CSRF hash; the rest is filled in.
+func (cfg *apiConfig) issueAccessToken(user *models.User, claims *accessClaims) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.Role = user.Role
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        tokenID,
+		Issuer:    "chirpy",
+		Subject:   strconv.Itoa(user.Id),
+		Audience:  jwt.ClaimStrings{cfg.tokens.Audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(cfg.tokens.ttlFor(user))),
+	}
+
+	return cfg.signAccessToken(claims)
+}
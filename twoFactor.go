@@ -0,0 +1,329 @@
+package main
+
+import (
+	"Chirpy/database"
+	"Chirpy/models"
+	"Chirpy/password"
+	"Chirpy/totp"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	loginChallengeTTL         = 5 * time.Minute
+	loginChallengeMaxAttempts = 5
+	recoveryCodeCount         = 10
+	totpIssuer                = "Chirpy"
+)
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type disableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type verifyLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type twoFactorEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type loginChallengeResponse struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// enrollTwoFactor hands out a new secret. The otpauth URI is what goes into
+// the QR code for authenticator apps.
+func (cfg *apiConfig) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating secret")
+		return
+	}
+
+	err = db.SetPendingTOTPSecret(userID, secret)
+	if errors.Is(err, database.ErrConflict) {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving secret")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, twoFactorEnrollmentResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// confirmTwoFactor turns 2FA on once the user proves their app produces codes
+// for the pending secret. Recovery codes are only ever shown in this response.
+func (cfg *apiConfig) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	var request twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	if user.TwoFactorEnabled || user.PendingTOTPSecret == "" {
+		respondWithError(w, http.StatusConflict, "There is no two-factor enrollment to confirm")
+		return
+	}
+
+	counter, ok := totp.Validate(user.PendingTOTPSecret, request.Code, time.Now(), 0)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := cfg.newRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating recovery codes")
+		return
+	}
+
+	err = db.EnableTwoFactor(userID, user.PendingTOTPSecret, counter, hashes)
+	if errors.Is(err, database.ErrConflict) {
+		respondWithError(w, http.StatusConflict, "There is no two-factor enrollment to confirm")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error enabling two-factor authentication")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// disableTwoFactor needs both the password and a second factor, so a stolen
+// access token alone can't turn 2FA off.
+func (cfg *apiConfig) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Error extracting subject claims")
+		return
+	}
+
+	var request disableTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is not enabled")
+		return
+	}
+
+	equalPass, err := password.Verify(request.Password, user.Password)
+	if err != nil || !equalPass || !cfg.checkSecondFactor(db, user, request.Code) {
+		respondWithError(w, http.StatusForbidden, "Wrong password or code")
+		return
+	}
+
+	err = db.DisableTwoFactor(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error disabling two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startLoginChallenge answers a correct password on a 2FA account. The
+// challenge token is useless without a code and expires quickly.
+func (cfg *apiConfig) startLoginChallenge(w http.ResponseWriter, db *database.DB, user *models.User, device string, scope string, cookie bool) {
+	token, err := newSecretToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating login challenge")
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(loginChallengeTTL)
+
+	err = db.CreateLoginChallenge(user.Id, hashToken(token), expiresAt, device, scope, cookie)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating login challenge")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, loginChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresAt:         expiresAt,
+	})
+}
+
+// verifyLogin exchanges a login challenge and a TOTP or recovery code for the
+// usual access and refresh tokens.
+func (cfg *apiConfig) verifyLogin(w http.ResponseWriter, r *http.Request) {
+	var request verifyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding request body")
+		return
+	}
+
+	db, err := database.NewDB("database.json")
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+	}
+
+	challenge, err := db.GetLoginChallenge(hashToken(request.ChallengeToken))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Login challenge is invalid or has expired")
+		return
+	}
+
+	user, err := db.GetUser(challenge.UserId)
+	if err != nil || !user.TwoFactorEnabled {
+		respondWithError(w, http.StatusUnauthorized, "Login challenge is invalid or has expired")
+		return
+	}
+
+	if !cfg.checkSecondFactor(db, user, request.Code) {
+		err = db.FailLoginChallenge(challenge.Id, loginChallengeMaxAttempts)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			fmt.Printf("Error recording failed login challenge: %v\n", err)
+		}
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	err = db.DeleteLoginChallenge(challenge.Id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Login challenge is invalid or has expired")
+		return
+	}
+
+	if user.Suspended {
+		respondWithError(w, http.StatusForbidden, "This account is suspended")
+		return
+	}
+
+	cfg.respondWithLogin(w, r, db, user, challenge.Device, challenge.Scope, challenge.Cookie)
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code,
+// and spends whichever one matched.
+func (cfg *apiConfig) checkSecondFactor(db *database.DB, user *models.User, code string) bool {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false
+	}
+
+	counter, ok := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastCounter)
+	if ok {
+		return db.UseTOTPCounter(user.Id, counter) == nil
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if len(normalized) != 10 {
+		return false
+	}
+
+	for _, hash := range user.RecoveryCodeHashes {
+		equal, err := password.Verify(normalized, hash)
+		if err == nil && equal {
+			return db.UseRecoveryCode(user.Id, hash) == nil
+		}
+	}
+
+	return false
+}
+
+// newRecoveryCodes returns codes like "k3m9q-x2b7d" and their hashes. They
+// are hashed like passwords since 50 bits are too few for a plain digest.
+func (cfg *apiConfig) newRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		randomBytes := make([]byte, 7)
+		if _, err := rand.Read(randomBytes); err != nil {
+			return nil, nil, err
+		}
+
+		raw := strings.ToLower(encoding.EncodeToString(randomBytes))[:10]
+
+		hash, err := cfg.passwordHasher.Hash(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hash)
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(code, "-", ""), " ", ""))
+}